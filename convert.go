@@ -0,0 +1,126 @@
+// Here we convert database and run files to and from the text notation of
+// machines, see lib_bbchallenge/notation.go
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	bbc "github.com/bbchallenge/bbchallenge/lib_bbchallenge"
+)
+
+func parseHaltSymbol(haltSymbol string) byte {
+	if len(haltSymbol) != 1 {
+		fmt.Println("The halt symbol must be a single character.")
+		os.Exit(-1)
+	}
+	return haltSymbol[0]
+}
+
+// Writes the machines of a database or run file on stdout, one per line
+func exportMachines(args []string, haltSymbol string) {
+	if len(args) != 1 {
+		fmt.Println("Give the database or run file to export, e.g. 'export output/run_2025-03-06_13-46-57_halting'.")
+		os.Exit(-1)
+	}
+	halt := parseHaltSymbol(haltSymbol)
+
+	db, err := bbc.OpenDatabaseOrRunFile(args[0])
+	if err != nil {
+		fmt.Println("Cannot read the machines:", err)
+		os.Exit(-1)
+	}
+	defer db.Close()
+
+	// The seed database holds 5-state machines, run files do not record
+	// their number of states
+	nbStates := byte(5)
+	if db.Header.Version > 0 {
+		// The text notation is written with 2 symbols
+		expected := db.Header.Parameters
+		expected.NbSymbols = 2
+		if err := db.CheckParameters(expected); err != nil {
+			fmt.Println("Cannot export these machines:", err)
+			os.Exit(-1)
+		}
+		nbStates = db.Header.Parameters.NbStates
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	it := db.Iterate(0, db.Len())
+	for it.Next() {
+		tm := it.TM()
+		if !db.HasHeader {
+			nbStates = tm.NbStates()
+		}
+		fmt.Fprintln(out, tm.ToText(nbStates, 2, halt))
+	}
+	if it.Err() != nil {
+		out.Flush()
+		fmt.Println(it.Err())
+		os.Exit(-1)
+	}
+}
+
+// Writes the machines of a text file, one per line, to a header-less run
+// file of 30-byte machines. Empty lines and lines starting with # are skipped.
+func importMachines(args []string, haltSymbol string) {
+	if len(args) != 2 {
+		fmt.Println("Give the text file to import and the run file to write, e.g. 'import machines.txt machines_halting'.")
+		os.Exit(-1)
+	}
+	halt := parseHaltSymbol(haltSymbol)
+
+	in, err := os.Open(args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	defer in.Close()
+
+	file, err := os.Create(args[1])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	out := bufio.NewWriter(file)
+
+	nbMachines := 0
+	scanner := bufio.NewScanner(in)
+	for lineNumber := 1; scanner.Scan(); lineNumber += 1 {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		tm, nbStates, nbSymbols, err := bbc.ParseTM(line, halt)
+		var encoded [bbc.LEGACY_TM_SIZE]byte
+		if err == nil {
+			encoded, err = bbc.EncodeLegacyTM(nbStates, nbSymbols, tm)
+		}
+		if err != nil {
+			fmt.Printf("%s:%d: %v\n", args[0], lineNumber, err)
+			file.Close()
+			os.Remove(args[1])
+			os.Exit(-1)
+		}
+
+		out.Write(encoded[:])
+		nbMachines += 1
+	}
+
+	err = scanner.Err()
+	if err == nil {
+		err = out.Flush()
+	}
+	file.Close()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	fmt.Println(nbMachines, "machines written to", args[1])
+}
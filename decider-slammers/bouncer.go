@@ -0,0 +1,232 @@
+// Here we recognize bouncers: machines sweeping back and forth between the
+// ends of the part of the tape they visited, which grows by the same number of
+// cells in each round so that the rounds get longer by the same number of
+// steps. They run for a quadratic number of steps in the tape length.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+
+	bbc "github.com/bbchallenge/bbchallenge/lib_bbchallenge"
+)
+
+// Minimal number of consecutive rounds growing regularly for a machine to be
+// a bouncer
+const BOUNCER_MIN_ROUNDS = 4
+
+// Number of tape lengths on which the cost function of a bouncer is checked by
+// simulation, besides the two it is derived from
+const BOUNCER_NB_VALIDATIONS = 3
+
+// Cost function of a bouncer, part of the results file
+type BouncerResult struct {
+	Rounds int `json:"rounds"` // Number of rounds growing regularly at the analysed tape length
+	Growth int `json:"growth"` // Cells added to the span of each round, negative if they shrink
+	Delta  int `json:"delta"`  // Steps added to each round
+
+	// Exact coefficients of the cost function a*t^2 + b*t + c, as fractions
+	A            string `json:"a"`
+	B            string `json:"b"`
+	C            string `json:"c"`
+	CostFunction string `json:"cost_function"`
+	// The cost function holds for tape lengths congruent to the analysed one
+	// modulo Modulus (the absolute growth of the rounds)
+	Modulus              int   `json:"modulus"`
+	ValidatedTapeLengths []int `json:"validated_tape_lengths"`
+}
+
+// Runs the machine on a tape of length tapeLength whose head stays put on the
+// edges and splits its run in sweeps, alternating between right and left:
+// a sweep to the right ends when the head is the farthest to the right before
+// going back more than half of the sweep, and conversely. Going back less
+// than that (e.g. to read a few cells again) does not end the sweep. Returns
+// the times at which the sweeps end (starting with time 0) and the number of
+// cells they span.
+func sweeps(tm bbc.TM, tapeLength int, maxSteps int) (times []int, widths []int, halted bool) {
+	tape := make([]byte, tapeLength)
+	state := byte(1)
+	head := 0
+
+	times, widths = []int{0}, []int{1}
+	start := 0 // Where the current sweep started
+	movingRight := true
+	extreme, extremeTime := 0, 0 // Farthest position of the current sweep
+
+	for time := 0; state != 0 && state != bbc.H; time += 1 {
+		if time >= maxSteps {
+			return times, widths, false
+		}
+
+		write, nextState, nextHead := bbc.LbaStep(tm, 2, tapeLength, state, tape[head], head)
+		if nextState == 0 {
+			break
+		}
+		tape[head] = write
+		state, head = nextState, nextHead
+
+		farther, back := head > extreme, 2*(extreme-head) > extreme-start
+		if !movingRight {
+			farther, back = head < extreme, 2*(head-extreme) > start-extreme
+		}
+
+		if farther {
+			extreme, extremeTime = head, time+1
+		} else if back {
+			times = append(times, extremeTime)
+			widths = append(widths, bbc.MaxI(extreme-start, start-extreme)+1)
+			start, movingRight = extreme, !movingRight
+			extreme, extremeTime = head, time+1
+		}
+	}
+	return times, widths, true
+}
+
+// Longest sequence of rounds (a sweep to the right followed by a sweep to the
+// left) growing by the same number of cells, each taking the same number of
+// steps more than the previous one (or shrinking, both being negative)
+func regularRounds(times []int, widths []int) (nbRounds int, growth int, delta int) {
+	var durations, growths []int
+	for i := 0; i+2 < len(times); i += 2 {
+		durations = append(durations, times[i+2]-times[i])
+		growths = append(growths, widths[i+2]-widths[i])
+	}
+
+	for start := 0; start+1 < len(durations); start += 1 {
+		// Rounds may also shrink, as when the machine sweeps between the edge
+		// of the tape and cells that it fills one by one
+		g, d := growths[start], durations[start+1]-durations[start]
+		if g == 0 || d == 0 || (g > 0) != (d > 0) {
+			continue
+		}
+
+		// Rounds start to end are regular, the growth of the last one is not
+		// checked as it may reach the edge of the tape
+		end := start + 1
+		for end+1 < len(durations) && growths[end] == g && durations[end+1]-durations[end] == d {
+			end += 1
+		}
+		if end-start+1 > nbRounds {
+			nbRounds, growth, delta = end-start+1, g, d
+		}
+	}
+	return nbRounds, growth, delta
+}
+
+// Number of steps of the machine on a tape of length tapeLength, false if it
+// does not halt
+func haltingTime(tm bbc.TM, tapeLength int) (int, bool) {
+	haltStatus, _, _, steps, _, _, _ := bbc.SimulateDetectCycles(tm, 2, math.MaxInt, tapeLength, bbc.BOUNDARY_STAY)
+	return steps, haltStatus == bbc.HALT
+}
+
+// Recognizes a bouncer from its run on a tape of length tapeLength, which
+// halted after steps steps. The leading coefficient of its cost function is
+// given by its rounds: there are about t/|growth| of them and they take
+// about |delta| steps more one after the other. The other two coefficients are
+// given by its halting time at tape lengths tapeLength and tapeLength+|growth|. The cost function is then checked on
+// BOUNCER_NB_VALIDATIONS larger tape lengths. Returns nil if the machine is
+// not a bouncer or if its cost function is wrong.
+func analyseBouncer(tm bbc.TM, tapeLength int, steps int, trace io.Writer) *BouncerResult {
+	times, widths, halted := sweeps(tm, tapeLength, steps+1)
+	if !halted {
+		return nil
+	}
+	nbRounds, growth, delta := regularRounds(times, widths)
+	if nbRounds < BOUNCER_MIN_ROUNDS {
+		return nil
+	}
+	fmt.Fprintf(trace, "Bouncer? %d rounds growing by %d cells and %d steps\n", nbRounds, growth, delta)
+
+	modulus := bbc.MaxI(growth, -growth)
+	t0 := big.NewRat(int64(tapeLength), 1)
+	g := big.NewRat(int64(modulus), 1)
+	t1 := new(big.Rat).Add(t0, g)
+
+	steps1, ok := haltingTime(tm, tapeLength+modulus)
+	if !ok {
+		fmt.Fprintln(trace, "Does not halt on a tape of length", tapeLength+modulus)
+		return nil
+	}
+
+	// a = |delta| / (2 growth^2)
+	a := big.NewRat(int64(bbc.MaxI(delta, -delta)), int64(2*growth*growth))
+	// b = (steps1 - steps - a*(t1^2 - t0^2)) / |growth|
+	b := new(big.Rat).Sub(new(big.Rat).Mul(t1, t1), new(big.Rat).Mul(t0, t0))
+	b.Mul(b, a)
+	b.Sub(big.NewRat(int64(steps1-steps), 1), b)
+	b.Quo(b, g)
+	// c = steps - a*t0^2 - b*t0
+	c := evaluateQuadratic(a, b, new(big.Rat), t0)
+	c.Sub(big.NewRat(int64(steps), 1), c)
+
+	result := &BouncerResult{
+		Rounds:       nbRounds,
+		Growth:       growth,
+		Delta:        delta,
+		A:            a.RatString(),
+		B:            b.RatString(),
+		C:            c.RatString(),
+		CostFunction: quadraticFormula(a, b, c),
+		Modulus:      modulus,
+	}
+
+	for i := 2; i < 2+BOUNCER_NB_VALIDATIONS; i += 1 {
+		t := tapeLength + i*modulus
+		expected := evaluateQuadratic(a, b, c, big.NewRat(int64(t), 1))
+		actual, ok := haltingTime(tm, t)
+		if !ok || expected.Cmp(big.NewRat(int64(actual), 1)) != 0 {
+			fmt.Fprintf(trace, "%s gives %s steps on a tape of length %d, the machine halted after %d steps (halted: %v)\n",
+				result.CostFunction, expected.RatString(), t, actual, ok)
+			return nil
+		}
+		result.ValidatedTapeLengths = append(result.ValidatedTapeLengths, t)
+	}
+	return result
+}
+
+func evaluateQuadratic(a *big.Rat, b *big.Rat, c *big.Rat, t *big.Rat) *big.Rat {
+	result := new(big.Rat).Mul(a, t)
+	result.Add(result, b)
+	result.Mul(result, t)
+	return result.Add(result, c)
+}
+
+// Formula with exact coefficients, e.g. "(3/2)t^2 - t + 4"
+func quadraticFormula(a *big.Rat, b *big.Rat, c *big.Rat) string {
+	result := ""
+	for i, term := range []struct {
+		coefficient *big.Rat
+		variable    string
+	}{{a, "t^2"}, {b, "t"}, {c, ""}} {
+		sign := term.coefficient.Sign()
+		if sign == 0 {
+			continue
+		}
+
+		switch {
+		case result == "" && sign < 0:
+			result = "-"
+		case result != "" && sign < 0:
+			result += " - "
+		case result != "":
+			result += " + "
+		}
+
+		abs := new(big.Rat).Abs(term.coefficient)
+		switch {
+		case !abs.IsInt():
+			result += "(" + abs.RatString() + ")"
+		case abs.Cmp(big.NewRat(1, 1)) != 0 || i == 2:
+			result += abs.RatString()
+		}
+		result += term.variable
+	}
+	if result == "" {
+		return "0"
+	}
+	return result
+}
@@ -0,0 +1,47 @@
+// Here we test the recognition of bouncers
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	bbc "github.com/bbchallenge/bbchallenge/lib_bbchallenge"
+)
+
+func TestAnalyseBouncer(t *testing.T) {
+	for _, test := range []struct {
+		machine      string
+		costFunction string // Empty if not a bouncer
+		modulus      int
+	}{
+		{"0LB---_0RC1LB_1RA1RC", "t^2", 1},
+		{"1LB0LC_1RA0LB_---0RB", "3t^2 - 3t + 5", 1},
+		// Sweeps between the right edge and cells filled from left to right
+		{"1RB0LA_0RC---_1RC1LA", "t^2 - 2t + 3", 1},
+		// Halting time depending on the parity of the tape length
+		{"1LB1LB_---1LC_1RA0RC", "t^2 + (1/2)t + (9/2)", 2},
+		// Translated cycler, linear
+		{"1RA0RB_1LA---", "", 0},
+	} {
+		tm, _, _, err := bbc.ParseTM(test.machine, bbc.DEFAULT_HALT_SYMBOL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		steps, halted := haltingTime(tm, 13)
+		if !halted {
+			t.Fatal(test.machine, "does not halt")
+		}
+
+		result := analyseBouncer(tm, 13, steps, ioutil.Discard)
+		if test.costFunction == "" {
+			if result != nil {
+				t.Error(test.machine, "is not a bouncer", *result)
+			}
+			continue
+		}
+		if result == nil || result.CostFunction != test.costFunction || result.Modulus != test.modulus ||
+			len(result.ValidatedTapeLengths) != BOUNCER_NB_VALIDATIONS {
+			t.Error(test.machine, result)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+// Here we sort halting machines in families by the way their number of steps
+// grows with the tape length, and summarize how many machines of each family
+// there are and which ones run the longest
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	bbc "github.com/bbchallenge/bbchallenge/lib_bbchallenge"
+)
+
+type Class byte
+
+const (
+	CLASS_SLAMMER           Class = iota // Translated cycler whose cycles have period 1
+	CLASS_TRANSLATED_CYCLER              // Linear number of steps
+	CLASS_BOUNCER                        // Quadratic number of steps
+	CLASS_COUNTER                        // Exponential number of steps
+	CLASS_CONSTANT                       // Never reaches the right edge of the tape
+	CLASS_UNCLASSIFIED
+	NB_CLASSES
+)
+
+var classNames = []string{"slammer", "translated_cycler", "bouncer", "counter", "constant", "unclassified"}
+
+func (c Class) String() string {
+	return classNames[c]
+}
+
+func (c Class) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// Number of larger tape lengths on which the cost function of a translated
+// cycler is checked by simulation, as a single run may find cycles in
+// machines that are not translated cyclers
+const TRANSLATED_CYCLER_NB_VALIDATIONS = 3
+
+// Cost functions and fits may only hold for tape lengths congruent to the
+// analysed one modulo some small number, e.g. when the number of steps
+// depends on the parity of the tape length. Moduli up to this one are tried.
+const CLASSIFY_MAX_MODULUS = 4
+
+// Counters are recognized by fitting their halting times on this number of
+// tape lengths, up to the analysed one
+const COUNTER_NB_TAPE_LENGTHS = 8
+
+// Smallest base b of the fit a*b^t + c for the machine to be counter-like,
+// fits of machines with a parity-dependent linear number of steps have bases
+// close to 1
+const COUNTER_MIN_BASE = 1.25
+
+// Halting times of a machine, the moduli tried by the classification share
+// tape lengths which are only simulated once
+type haltingTimes struct {
+	tm    bbc.TM
+	steps map[int]int // -1 if the machine does not halt
+}
+
+func newHaltingTimes(result *Result, tm bbc.TM) haltingTimes {
+	return haltingTimes{tm: tm, steps: map[int]int{result.TapeLength: result.Steps}}
+}
+
+func (h haltingTimes) get(tapeLength int) (int, bool) {
+	steps, ok := h.steps[tapeLength]
+	if !ok {
+		var halted bool
+		if steps, halted = haltingTime(h.tm, tapeLength); !halted {
+			steps = -1
+		}
+		h.steps[tapeLength] = steps
+	}
+	return steps, steps >= 0
+}
+
+// Smallest modulus m such that the halting times of the machine grow by
+// result.Coefficient steps per cell on the TRANSLATED_CYCLER_NB_VALIDATIONS
+// tape lengths result.TapeLength + i*m, 0 if there is none up to
+// CLASSIFY_MAX_MODULUS. The constant term found by the decider is not checked
+// as it is off for some translated cyclers.
+func linearModulus(result *Result, times haltingTimes) int {
+	for modulus := 1; modulus <= CLASSIFY_MAX_MODULUS; modulus += 1 {
+		valid := true
+		for i := 1; i <= TRANSLATED_CYCLER_NB_VALIDATIONS && valid; i += 1 {
+			steps, halted := times.get(result.TapeLength + i*modulus)
+			valid = halted && steps == result.Steps+result.Coefficient*i*modulus
+		}
+		if valid {
+			return modulus
+		}
+	}
+	return 0
+}
+
+// Exponential fit of the halting times of the machine on
+// COUNTER_NB_TAPE_LENGTHS tape lengths up to tapeLength, congruent to it
+// modulo the smallest modulus for which it is the best fit and grows fast
+// enough. Returns nil if there is none.
+func counterFit(times haltingTimes, tapeLength int) (*Fit, int) {
+	for modulus := 1; modulus <= CLASSIFY_MAX_MODULUS; modulus += 1 {
+		var tapeLengths, allSteps []int
+		for i := COUNTER_NB_TAPE_LENGTHS - 1; i >= 0; i -= 1 {
+			if t := tapeLength - i*modulus; t >= 1 {
+				if steps, halted := times.get(t); halted {
+					tapeLengths = append(tapeLengths, t)
+					allSteps = append(allSteps, steps)
+				}
+			}
+		}
+
+		_, best, ok := fitModels(tapeLengths, allSteps)
+		if ok && best.Model == MODEL_EXPONENTIAL && best.Coefficients[1] >= COUNTER_MIN_BASE {
+			return &best, modulus
+		}
+	}
+	return nil, 0
+}
+
+// Sets the class of the machine and its cost function from the results of its
+// analysis. A machine which never reaches the right edge of the tape has the
+// same number of steps on any longer tape. Slammers and translated cyclers
+// need halting times growing linearly on longer tapes, their cost function is
+// then given by their coefficient and their halting time. The other machines
+// are checked for counters, whose cost function is only approximate.
+func classify(result *Result, tm bbc.TM) {
+	result.Class = CLASS_UNCLASSIFIED
+	if !result.Halted {
+		return
+	}
+
+	if result.Space < result.TapeLength {
+		result.Class = CLASS_CONSTANT
+		result.ClassCostFunction = strconv.Itoa(result.Steps)
+		result.Modulus = 1
+		return
+	}
+
+	if result.Bouncer != nil {
+		result.Class = CLASS_BOUNCER
+		result.ClassCostFunction = result.Bouncer.CostFunction
+		result.Modulus = result.Bouncer.Modulus
+		return
+	}
+
+	times := newHaltingTimes(result, tm)
+	if result.NbCycles > 0 && result.Coefficient > 0 {
+		if modulus := linearModulus(result, times); modulus > 0 {
+			result.Class = CLASS_TRANSLATED_CYCLER
+			if result.Period == 1 {
+				result.Class = CLASS_SLAMMER
+			}
+			constant := result.Steps - result.Coefficient*result.TapeLength
+			result.ClassCostFunction = formula(MODEL_LINEAR, []float64{float64(result.Coefficient), float64(constant)})
+			result.Modulus = modulus
+			return
+		}
+	}
+
+	if counter, modulus := counterFit(times, result.TapeLength); counter != nil {
+		result.Class = CLASS_COUNTER
+		result.ClassCostFunction = "~ " + counter.Formula
+		result.Modulus = modulus
+		result.Counter = counter
+	}
+}
+
+// Number of machines of each class by number of states, and the machine with
+// the most steps in each class. Results must be added in the order of the
+// database so that ties go to the first machine.
+type classReport struct {
+	counts    map[int]*[NB_CLASSES]int
+	champions [NB_CLASSES]*Result
+}
+
+func newClassReport() classReport {
+	return classReport{counts: make(map[int]*[NB_CLASSES]int)}
+}
+
+func (r *classReport) add(result Result) {
+	counts, ok := r.counts[result.NbStates]
+	if !ok {
+		counts = new([NB_CLASSES]int)
+		r.counts[result.NbStates] = counts
+	}
+	counts[result.Class] += 1
+
+	champion := r.champions[result.Class]
+	if result.Halted && (champion == nil || result.Steps > champion.Steps) {
+		r.champions[result.Class] = &result
+	}
+}
+
+func (r classReport) print(tapeLength int) {
+	var allStates []int
+	for nbStates := range r.counts {
+		allStates = append(allStates, nbStates)
+	}
+	sort.Ints(allStates)
+
+	headers := []string{"class"}
+	for _, nbStates := range allStates {
+		headers = append(headers, fmt.Sprintf("%d states", nbStates))
+	}
+	headers = append(headers, "total")
+
+	var table [][]string
+	totals := make([]int, len(allStates)+1)
+	for class := Class(0); class < NB_CLASSES; class += 1 {
+		row := []string{class.String()}
+		total := 0
+		for i, nbStates := range allStates {
+			count := r.counts[nbStates][class]
+			row = append(row, strconv.Itoa(count))
+			totals[i] += count
+			total += count
+		}
+		totals[len(allStates)] += total
+		table = append(table, append(row, strconv.Itoa(total)))
+	}
+	row := []string{"total"}
+	for _, total := range totals {
+		row = append(row, strconv.Itoa(total))
+	}
+	table = append(table, row)
+	fmt.Print(bbc.AsciiTable(table, headers))
+
+	table = nil
+	for class := Class(0); class < NB_CLASSES; class += 1 {
+		champion := r.champions[class]
+		if champion == nil {
+			continue
+		}
+		costFunction := champion.ClassCostFunction
+		if costFunction == "" {
+			costFunction = "-"
+		}
+		table = append(table, []string{class.String(), strconv.Itoa(champion.Index), champion.TM,
+			strconv.Itoa(champion.Steps), costFunction})
+	}
+	if len(table) > 0 {
+		fmt.Println("Champions (most steps on a tape of length", tapeLength, "in each class):")
+		fmt.Print(bbc.AsciiTable(table, []string{"class", "index", "machine", "steps", "cost function"}))
+	}
+}
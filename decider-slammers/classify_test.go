@@ -0,0 +1,65 @@
+// Here we test the classification of halting machines
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	bbc "github.com/bbchallenge/bbchallenge/lib_bbchallenge"
+)
+
+func TestClassify(t *testing.T) {
+	for _, test := range []struct {
+		machine      string
+		class        Class
+		costFunction string // Prefix of the cost function, fits of counters are approximate
+		modulus      int
+	}{
+		{"1RA1RB_---0LB", CLASS_SLAMMER, "2t + 2", 1},
+		{"1LB---_0LA1LC_0RB1RC", CLASS_TRANSLATED_CYCLER, "7t - 9", 1},
+		// Halting time depending on the parity of the tape length
+		{"1LB---_1RC1LC_0RA0RC", CLASS_TRANSLATED_CYCLER, "2t + 6", 2},
+		{"0LB---_0RC1LB_1RA1RC", CLASS_BOUNCER, "t^2", 1},
+		// Doubling its halting time every other tape length
+		{"1LB0LA_1LA1RC_1RB---", CLASS_COUNTER, "~ ", 2},
+		{"1LB---_---1LC_---0LC", CLASS_CONSTANT, "4", 1},
+		// Translated cycler whose coefficient is not found by the decider
+		{"1LB0RB_---0RC_1RC1LA", CLASS_UNCLASSIFIED, "", 0},
+	} {
+		tm, nbStates, _, err := bbc.ParseTM(test.machine, bbc.DEFAULT_HALT_SYMBOL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result := analyseMachine(0, tm, nbStates, 13, ioutil.Discard)
+		if result.Class != test.class || !strings.HasPrefix(result.ClassCostFunction, test.costFunction) || result.Modulus != test.modulus {
+			t.Errorf("%s: got %s %q modulo %d, expected %s %q modulo %d", test.machine,
+				result.Class, result.ClassCostFunction, result.Modulus, test.class, test.costFunction, test.modulus)
+		}
+	}
+}
+
+func TestClassReport(t *testing.T) {
+	report := newClassReport()
+	for _, result := range []Result{
+		{Index: 0, Halted: true, Steps: 10, NbStates: 2, Class: CLASS_SLAMMER},
+		{Index: 1, Halted: true, Steps: 12, NbStates: 3, Class: CLASS_SLAMMER},
+		{Index: 2, Halted: true, Steps: 12, NbStates: 3, Class: CLASS_SLAMMER},
+		{Index: 3, Halted: false, Steps: 100, NbStates: 3, Class: CLASS_UNCLASSIFIED},
+	} {
+		report.add(result)
+	}
+
+	if report.counts[2][CLASS_SLAMMER] != 1 || report.counts[3][CLASS_SLAMMER] != 2 || report.counts[3][CLASS_UNCLASSIFIED] != 1 {
+		t.Error("wrong counts", *report.counts[2], *report.counts[3])
+	}
+	// Ties go to the first machine
+	if report.champions[CLASS_SLAMMER].Index != 1 {
+		t.Error("wrong slammer champion", report.champions[CLASS_SLAMMER].Index)
+	}
+	// Machines which do not halt are not champions
+	if report.champions[CLASS_UNCLASSIFIED] != nil {
+		t.Error("non-halting champion", report.champions[CLASS_UNCLASSIFIED].Index)
+	}
+}
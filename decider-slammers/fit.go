@@ -0,0 +1,301 @@
+// Here we fit closed forms to the halting times of a machine over a range of
+// tape lengths, instead of deriving them from a single run
+
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	bbc "github.com/bbchallenge/bbchallenge/lib_bbchallenge"
+)
+
+type Model byte
+
+const (
+	MODEL_LINEAR      Model = iota // a*t + b
+	MODEL_QUADRATIC                // a*t^2 + b*t + c
+	MODEL_EXPONENTIAL              // a*b^t + c
+)
+
+var modelNames = []string{"linear", "quadratic", "exponential"}
+
+func (m Model) String() string {
+	return modelNames[m]
+}
+
+func (m Model) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// Number of coefficients of the model, a fit needs one more point than that
+// to tell anything
+func (m Model) nbCoefficients() int {
+	if m == MODEL_LINEAR {
+		return 2
+	}
+	return 3
+}
+
+// A model fits when it gives all halting times up to floating point errors,
+// relatively to the largest one
+const FIT_TOLERANCE = 1e-9
+
+// A model fitted to the halting times by least squares
+type Fit struct {
+	Model        Model     `json:"model"`
+	Formula      string    `json:"formula"`
+	Coefficients []float64 `json:"coefficients"`
+	MaxResidual  float64   `json:"max_residual"`
+	RMSResidual  float64   `json:"rms_residual"`
+	Exact        bool      `json:"exact"` // Whether the model gives all halting times
+}
+
+// Solves the least squares problem rows * x = ys with the normal equations.
+// Returns false if the rows do not determine x.
+func leastSquares(rows [][]float64, ys []float64) ([]float64, bool) {
+	n := len(rows[0])
+
+	// Augmented matrix of the normal equations
+	a := make([][]float64, n)
+	for i := range a {
+		a[i] = make([]float64, n+1)
+		for k, row := range rows {
+			for j := 0; j < n; j += 1 {
+				a[i][j] += row[i] * row[j]
+			}
+			a[i][n] += row[i] * ys[k]
+		}
+	}
+
+	// Gaussian elimination with partial pivoting
+	for column := 0; column < n; column += 1 {
+		pivot := column
+		for i := column + 1; i < n; i += 1 {
+			if math.Abs(a[i][column]) > math.Abs(a[pivot][column]) {
+				pivot = i
+			}
+		}
+		if math.Abs(a[pivot][column]) < 1e-12 {
+			return nil, false
+		}
+		a[column], a[pivot] = a[pivot], a[column]
+
+		for i := 0; i < n; i += 1 {
+			if i == column {
+				continue
+			}
+			factor := a[i][column] / a[column][column]
+			for j := column; j <= n; j += 1 {
+				a[i][j] -= factor * a[column][j]
+			}
+		}
+	}
+
+	x := make([]float64, n)
+	for i := range x {
+		x[i] = a[i][n] / a[i][i]
+	}
+	return x, true
+}
+
+// Fits the model to the halting times steps[i] at tape lengths tapeLengths[i].
+// Returns false if there are not enough points or if the model does not apply
+// (exponential fits need increasing halting times).
+func fitModel(model Model, tapeLengths []int, steps []int) (Fit, bool) {
+	if len(tapeLengths) <= model.nbCoefficients() {
+		return Fit{}, false
+	}
+
+	ys := make([]float64, len(steps))
+	for i, s := range steps {
+		ys[i] = float64(s)
+	}
+
+	var predict func(t float64) float64
+	var coefficients []float64
+	var ok bool
+
+	switch model {
+	case MODEL_LINEAR, MODEL_QUADRATIC:
+		var rows [][]float64
+		for _, t := range tapeLengths {
+			row := []float64{float64(t), 1}
+			if model == MODEL_QUADRATIC {
+				row = []float64{float64(t * t), float64(t), 1}
+			}
+			rows = append(rows, row)
+		}
+		if coefficients, ok = leastSquares(rows, ys); !ok {
+			return Fit{}, false
+		}
+		predict = func(t float64) float64 {
+			y := 0.
+			for _, c := range coefficients {
+				y = y*t + c
+			}
+			return y
+		}
+
+	case MODEL_EXPONENTIAL:
+		// The differences of a*b^t + c between consecutive tape lengths are
+		// a*(b-1)*b^t: their logarithm is linear in t and gives b, then a and
+		// c are a linear fit in b^t
+		var rows [][]float64
+		var logs []float64
+		for i := 1; i < len(tapeLengths); i += 1 {
+			difference := (ys[i] - ys[i-1]) / float64(tapeLengths[i]-tapeLengths[i-1])
+			if difference <= 0 {
+				return Fit{}, false
+			}
+			rows = append(rows, []float64{float64(tapeLengths[i]), 1})
+			logs = append(logs, math.Log(difference))
+		}
+		logFit, ok := leastSquares(rows, logs)
+		if !ok || logFit[0] < 1e-6 { // Not growing exponentially
+			return Fit{}, false
+		}
+		base := math.Exp(logFit[0])
+
+		rows = nil
+		for _, t := range tapeLengths {
+			rows = append(rows, []float64{math.Pow(base, float64(t)), 1})
+		}
+		linear, ok := leastSquares(rows, ys)
+		if !ok {
+			return Fit{}, false
+		}
+		coefficients = []float64{linear[0], base, linear[1]}
+		predict = func(t float64) float64 {
+			return linear[0]*math.Pow(base, t) + linear[1]
+		}
+	}
+
+	fit := Fit{Model: model, Coefficients: coefficients}
+	sumSquares := 0.
+	maxSteps := 1.
+	for i, t := range tapeLengths {
+		residual := math.Abs(predict(float64(t)) - ys[i])
+		fit.MaxResidual = math.Max(fit.MaxResidual, residual)
+		sumSquares += residual * residual
+		maxSteps = math.Max(maxSteps, ys[i])
+	}
+	fit.RMSResidual = math.Sqrt(sumSquares / float64(len(tapeLengths)))
+	fit.Exact = fit.MaxResidual <= FIT_TOLERANCE*maxSteps
+	fit.Formula = formula(model, coefficients)
+	return fit, true
+}
+
+// Fits all models, the best one is the simplest that fits the halting times
+// (linear, then quadratic, then exponential) or the one with the smallest
+// residuals if none does
+func fitModels(tapeLengths []int, steps []int) (fits []Fit, best Fit, ok bool) {
+	for model := MODEL_LINEAR; model <= MODEL_EXPONENTIAL; model += 1 {
+		if fit, ok := fitModel(model, tapeLengths, steps); ok {
+			fits = append(fits, fit)
+		}
+	}
+	if len(fits) == 0 {
+		return nil, Fit{}, false
+	}
+
+	best = fits[0]
+	for _, fit := range fits[1:] {
+		if !best.Exact && (fit.Exact || fit.RMSResidual < best.RMSResidual) {
+			best = fit
+		}
+	}
+	return fits, best, true
+}
+
+// Formats a coefficient with at most 6 significant digits, without the
+// rounding noise of exact fits
+func formatCoefficient(x float64) string {
+	if rounded := math.Round(x); math.Abs(x-rounded) < 1e-6 {
+		x = rounded + 0 // No -0
+	}
+	return strconv.FormatFloat(x, 'g', 6, 64)
+}
+
+// Formula of the model as a function of the tape length t, e.g. "2t + 3"
+func formula(model Model, coefficients []float64) string {
+	var terms []string
+	var variables []string
+	switch model {
+	case MODEL_LINEAR:
+		variables = []string{"t", ""}
+	case MODEL_QUADRATIC:
+		variables = []string{"t^2", "t", ""}
+	case MODEL_EXPONENTIAL:
+		factor := formatCoefficient(coefficients[0]) + "*"
+		if factor == "1*" {
+			factor = ""
+		}
+		terms = append(terms, factor+formatCoefficient(coefficients[1])+"^t")
+		coefficients = coefficients[2:]
+		variables = []string{""}
+	}
+
+	for i, c := range coefficients {
+		coefficient := formatCoefficient(c)
+		if coefficient == "0" {
+			continue
+		}
+		if variables[i] != "" && (coefficient == "1" || coefficient == "-1") {
+			coefficient = strings.TrimSuffix(coefficient, "1")
+		}
+		terms = append(terms, coefficient+variables[i])
+	}
+	if len(terms) == 0 {
+		return "0"
+	}
+
+	result := strings.Join(terms, " + ")
+	return strings.ReplaceAll(result, "+ -", "- ")
+}
+
+// Outcome of the fit of one machine, one JSON line of the results file with -fit
+type FitResult struct {
+	Index       int    `json:"index"`
+	TM          string `json:"tm"`
+	TapeLengths []int  `json:"tape_lengths"` // Tape lengths on which the machine halts
+	Steps       []int  `json:"steps"`
+	NotHalting  []int  `json:"not_halting,omitempty"` // Tape lengths on which it does not
+	Fits        []Fit  `json:"fits"`
+	Best        *Fit   `json:"best"`        // nil if it halts on too few tape lengths to fit any model
+	Interesting bool   `json:"interesting"` // Whether no model fits the halting times
+}
+
+// Runs the machine on each tape length from `from` to `to` (both included),
+// with the head staying put on the edges, and fits the models to its halting
+// times
+func fitMachine(tm bbc.TM, from int, to int) FitResult {
+	var tapeLengths []int
+	for tapeLength := from; tapeLength <= to; tapeLength += 1 {
+		tapeLengths = append(tapeLengths, tapeLength)
+	}
+	return fitMachineOn(tm, tapeLengths)
+}
+
+// Same as fitMachine on the given increasing tape lengths
+func fitMachineOn(tm bbc.TM, tapeLengths []int) FitResult {
+	var result FitResult
+	for _, tapeLength := range tapeLengths {
+		haltStatus, _, _, steps, _, _, _ := bbc.SimulateDetectCycles(tm, 2, math.MaxInt, tapeLength, bbc.BOUNDARY_STAY)
+		if haltStatus != bbc.HALT {
+			result.NotHalting = append(result.NotHalting, tapeLength)
+			continue
+		}
+		result.TapeLengths = append(result.TapeLengths, tapeLength)
+		result.Steps = append(result.Steps, steps)
+	}
+
+	fits, best, ok := fitModels(result.TapeLengths, result.Steps)
+	result.Fits = fits
+	if ok {
+		result.Best = &best
+		result.Interesting = !best.Exact
+	}
+	return result
+}
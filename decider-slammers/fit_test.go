@@ -0,0 +1,47 @@
+// Here we test the fit of closed forms to halting times
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFitModels(t *testing.T) {
+	tapeLengths := []int{4, 5, 6, 7, 8, 9}
+	steps := func(f func(t int) int) []int {
+		var s []int
+		for _, t := range tapeLengths {
+			s = append(s, f(t))
+		}
+		return s
+	}
+
+	for _, test := range []struct {
+		steps   []int
+		model   Model
+		formula string
+	}{
+		{steps(func(t int) int { return 2*t + 3 }), MODEL_LINEAR, "2t + 3"},
+		{steps(func(t int) int { return t - 1 }), MODEL_LINEAR, "t - 1"},
+		{steps(func(t int) int { return t*t + 2*t }), MODEL_QUADRATIC, "t^2 + 2t"},
+		{steps(func(t int) int { return 3*(t*t-t)/2 + 5 }), MODEL_QUADRATIC, "1.5t^2 - 1.5t + 5"},
+		{steps(func(t int) int { return 1<<t + 3 }), MODEL_EXPONENTIAL, "2^t + 3"},
+		{steps(func(t int) int { return 2*int(math.Pow(3, float64(t))) - 1 }), MODEL_EXPONENTIAL, "2*3^t - 1"},
+	} {
+		_, best, ok := fitModels(tapeLengths, test.steps)
+		if !ok || !best.Exact || best.Model != test.model || best.Formula != test.formula {
+			t.Error(test.steps, best.Model, best.Formula, best.MaxResidual)
+		}
+	}
+
+	// Halting times depending on the parity of the tape length
+	_, best, ok := fitModels(tapeLengths, []int{5, 16, 7, 22, 9, 28})
+	if !ok || best.Exact {
+		t.Error(best)
+	}
+
+	// Not enough tape lengths
+	if _, _, ok := fitModels([]int{4, 5}, []int{1, 2}); ok {
+		t.Error("fitted two points")
+	}
+}
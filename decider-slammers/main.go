@@ -3,17 +3,19 @@
 package main
 
 import (
-	"encoding/binary"
+	"bufio"
+	"encoding/json"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
 	"os"
+	"runtime"
+	"time"
 
-	bbc "github.com/drkitt/bbchallenge-go"
+	bbc "github.com/bbchallenge/bbchallenge/lib_bbchallenge"
 )
 
-// Where to find the file that contains the halting machines
-const DATABASE_PATH = "./run_2025-03-06_13-46-57_halting"
-
 // Represents a single square on the tape along with some metadata
 type TapePosition struct {
 	Symbol       byte
@@ -28,6 +30,32 @@ type Record struct {
 	Position int
 }
 
+// Outcome of the decider on one machine, one JSON line of the results file
+type Result struct {
+	Index        int    `json:"index"`
+	TM           string `json:"tm"`
+	TapeLength   int    `json:"tape_length"`
+	Halted       bool   `json:"halted"` // False if the machine ran past the upper bound of the tape length
+	Steps        int    `json:"steps"`
+	NbCycles     int    `json:"nb_cycles"` // Number of translated cycles found, at most one per sweep of the tape
+	Coefficient  int    `json:"coefficient"`
+	Constant     int    `json:"constant"`
+	CostFunction string `json:"cost_function"`
+	Exact        bool   `json:"exact"`  // Whether the cost function gives the number of steps
+	Space        int    `json:"space"`  // Number of cells visited, starting from the left edge
+	Period       int    `json:"period"` // Longest period of the translated cycles found
+
+	Bouncer *BouncerResult `json:"bouncer,omitempty"` // Quadratic cost function, if the machine is a bouncer
+
+	NbStates int   `json:"nb_states"` // Number of states used by the machine
+	Class    Class `json:"class"`
+	// Number of steps as a function of the tape length t according to the
+	// class, on tape lengths congruent to TapeLength modulo Modulus
+	ClassCostFunction string `json:"class_cost_function,omitempty"`
+	Modulus           int    `json:"modulus,omitempty"`
+	Counter           *Fit   `json:"counter,omitempty"` // Exponential fit of the halting times, if the machine is counter-like
+}
+
 func recordsAreEquivalent(movingRight bool, pastRecord *Record, currentRecord *Record) bool {
 	offset := 0
 
@@ -101,8 +129,10 @@ func getStatus(currentTime int, currentState byte, symbolRead byte, tape []TapeP
 }
 
 // Gets the coefficient and constant of an LBA's cost function, assuming it runs
-// in linear time
-func calculateLinearCostFunction(lba bbc.LBA, tapeLength int) (int, int) {
+// in linear time. The machine is run on a tape of length tapeLength whose head
+// stays put on the edges, for at most maxSteps steps. The details of the
+// search are written to trace.
+func calculateLinearCostFunction(tm bbc.TM, tapeLength int, maxSteps int, trace io.Writer) Result {
 	var tape []TapePosition = make([]TapePosition, tapeLength)
 	// The max/min position seen by the machine so far in each state
 	var maxPositionSeen map[byte]int = make(map[byte]int)
@@ -124,13 +154,20 @@ func calculateLinearCostFunction(lba bbc.LBA, tapeLength int) (int, int) {
 	previousCycleEndTime := 0
 	// Used to construct the machine's cost function
 	coefficient, constant := 0, 0
+	nbCycles, maxPeriod := 0, 0
+	space := 1
+
+	for currentState > 0 && currentState != bbc.H {
+		if currentTime >= maxSteps {
+			fmt.Fprintf(trace, "Still running after %d steps, giving up\n", currentTime)
+			return Result{TapeLength: tapeLength, Steps: currentTime, Space: space}
+		}
 
-	for currentState > 0 {
 		symbolRead := tape[currentPosition].Symbol
 
 		// Detect hitting the edge of the tape
 		if currentPosition == previousPosition {
-			fmt.Println("🥺 hi tape edge")
+			fmt.Fprintln(trace, "🥺 hi tape edge")
 
 			// Remove old records
 			for k := range records {
@@ -161,8 +198,8 @@ func calculateLinearCostFunction(lba bbc.LBA, tapeLength int) (int, int) {
 				minPositionSeen[currentState] = tapeLength
 			}
 			if (movingRight && currentPosition > maxPositionSeen[currentState]) || (!movingRight && currentPosition < minPositionSeen[currentState]) {
-				fmt.Println("New record")
-				fmt.Println(getStatus(currentTime, currentState, symbolRead, tape, currentPosition))
+				fmt.Fprintln(trace, "New record")
+				fmt.Fprintln(trace, getStatus(currentTime, currentState, symbolRead, tape, currentPosition))
 
 				var record Record
 				record.Tape = make([]TapePosition, tapeLength)
@@ -179,14 +216,16 @@ func calculateLinearCostFunction(lba bbc.LBA, tapeLength int) (int, int) {
 				if _, ok := records[currentState][symbolRead]; ok {
 					for _, previousRecord := range records[currentState][symbolRead] {
 
-						fmt.Println("Comparing records:")
-						fmt.Println("\t", tapeString(previousRecord.Tape, previousRecord.Position, currentState))
-						fmt.Println("\t", tapeString(record.Tape, record.Position, currentState))
+						fmt.Fprintln(trace, "Comparing records:")
+						fmt.Fprintln(trace, "\t", tapeString(previousRecord.Tape, previousRecord.Position, currentState))
+						fmt.Fprintln(trace, "\t", tapeString(record.Tape, record.Position, currentState))
 
 						if recordsAreEquivalent(movingRight, &previousRecord, &record) {
 							period := currentTime - previousRecord.Time
 							constantSection := previousRecord.Time - previousCycleEndTime
-							fmt.Printf("oh my god it's a translated cycler (preperiod: %d, period: %d)\n", constantSection, period)
+							fmt.Fprintf(trace, "oh my god it's a translated cycler (preperiod: %d, period: %d)\n", constantSection, period)
+							nbCycles += 1
+							maxPeriod = bbc.MaxI(maxPeriod, period)
 
 							// Go doesn't have an absolute value function for integers :/
 							distanceTraveledInPeriod := previousRecord.Position - record.Position
@@ -221,7 +260,7 @@ func calculateLinearCostFunction(lba bbc.LBA, tapeLength int) (int, int) {
 							// head bonks against the edge of the tape.
 							constant += 1
 
-							fmt.Println("Moving to edge of tape...")
+							fmt.Fprintln(trace, "Moving to edge of tape...")
 							searchingForPeriod = false
 						}
 					}
@@ -232,116 +271,329 @@ func calculateLinearCostFunction(lba bbc.LBA, tapeLength int) (int, int) {
 				maxPositionSeen[currentState] = bbc.MaxI(maxPositionSeen[currentState], currentPosition)
 				minPositionSeen[currentState] = bbc.MinI(minPositionSeen[currentState], currentPosition)
 
-				fmt.Println()
+				fmt.Fprintln(trace)
 			}
 
 			tape[currentPosition].LastTimeSeen = currentTime
 		}
 
 		// Take a step
-		toWrite, currentState, nextPosition = bbc.LbaStep(lba, tapeLength, symbolRead, currentState, currentPosition, currentTime)
+		toWrite, currentState, nextPosition = bbc.LbaStep(tm, 2, tapeLength, currentState, symbolRead, currentPosition)
 
 		tape[currentPosition].Symbol = toWrite
 		previousPosition = currentPosition
 		currentPosition = nextPosition
 		currentTime += 1
+		space = bbc.MaxI(space, currentPosition+1)
 	}
 
 	// Record the steps since the end of the last cycle as a constant section
 	constant += currentTime - previousCycleEndTime
 
-	fmt.Printf("Halted at time %d (coefficient: %d, constant: %d)\n", currentTime, coefficient, constant)
+	fmt.Fprintf(trace, "Halted at time %d (coefficient: %d, constant: %d)\n", currentTime, coefficient, constant)
 
 	// Did you know?
 	// Halting translated cyclers with repeating period 1 are called slammers.
 	// For more information, see https://www.youtube.com/watch?v=XYq08kJGp4M
 
-	if currentTime != coefficient*tapeLength+constant {
-		log.Printf("❗️ Warning: The machine did not halt in the expected time (cost function gives runtime of %d, but the machine halted at time %d)\n", coefficient*tapeLength+constant, currentTime)
+	exact := currentTime == coefficient*tapeLength+constant
+	if !exact {
+		fmt.Fprintf(trace, "❗️ Warning: The machine did not halt in the expected time (cost function gives runtime of %d, but the machine halted at time %d)\n", coefficient*tapeLength+constant, currentTime)
 	}
 
-	return coefficient, constant
+	return Result{
+		TapeLength:   tapeLength,
+		Halted:       true,
+		Steps:        currentTime,
+		NbCycles:     nbCycles,
+		Coefficient:  coefficient,
+		Constant:     constant,
+		CostFunction: formula(MODEL_LINEAR, []float64{float64(coefficient), float64(constant)}),
+		Exact:        exact,
+		Space:        space,
+		Period:       maxPeriod,
+	}
 }
 
-func main() {
-	var maxConstantTerm int = -1
-	var constantChampionIndex uint32 = 0
-	var maxCoefficient int = -1
-	var linearChampionIndex uint32 = 0
-
-	database, error := os.ReadFile(DATABASE_PATH)
+// Number of states of the machine, as given by the database header if any
+func machineNbStates(db *bbc.Database, tm bbc.TM) byte {
+	if !db.HasHeader {
+		return tm.NbStates()
+	}
+	if db.Header.Version > 0 {
+		return db.Header.Parameters.NbStates
+	}
+	return 5
+}
 
-	if error != nil {
-		fmt.Println(error)
+// Writes a result as one JSON line
+func writeResult(output io.Writer, result interface{}) {
+	asJson, err := json.Marshal(result)
+	if err == nil {
+		_, err = output.Write(append(asJson, '\n'))
+	}
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(-1)
 	}
+}
 
-	fmt.Println("Hi 🥺 :3")
+// Looks for translated cycles in the run of the machine on a tape of length
+// tapeLength, checks whether it is a bouncer and classifies it
+func analyseMachine(index int, tm bbc.TM, nbStates byte, tapeLength int, trace io.Writer) Result {
+	fmt.Fprintln(trace, "Machine", index)
+	fmt.Fprintln(trace, tm.ToAsciiTable(nbStates, 2))
+
+	maxSteps := bbc.UpperBound(nbStates, 2, tapeLength)
+	result := calculateLinearCostFunction(tm, tapeLength, maxSteps, trace)
+	result.Index = index
+	result.TM = tm.ToText(nbStates, 2, bbc.DEFAULT_HALT_SYMBOL)
+	result.NbStates = int(tm.NbStates())
+	if result.Halted {
+		result.Bouncer = analyseBouncer(tm, tapeLength, result.Steps, trace)
+	}
+	classify(&result, tm)
 
-	// The BBChallenge deciders subtracted 1 from this quantity to account for
-	// the 30-byte header, but we don't have a header so we don't do that
-	databaseSize := (len(database) / 30)
-	fmt.Println(databaseSize)
+	fmt.Fprintln(trace)
+	fmt.Fprintln(trace, "Cost function:", result.CostFunction)
+	if result.Bouncer != nil {
+		fmt.Fprintln(trace, "Bouncer, cost function:", result.Bouncer.CostFunction)
+	}
+	if result.Counter != nil {
+		fmt.Fprintln(trace, "Counter, fit:", result.Counter.Formula)
+	}
+	fmt.Fprintln(trace, "Class:", result.Class)
 
-	// Create output file
-	outputFile, error := os.OpenFile("output/"+bbc.GetRunName(),
-		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if error != nil {
-		log.Fatal(error)
+	fmt.Fprintln(trace)
+	fmt.Fprintln(trace, "----------------------------------------")
+	fmt.Fprintln(trace)
+	return result
+}
+
+// Counts of the results and champions, the results being added in the order
+// of the database so that ties go to the first machine
+type summary struct {
+	classes               classReport
+	nbExact, nbNotHalting int
+
+	maxConstantTerm       int
+	constantChampionIndex int
+	maxCoefficient        int
+	linearChampionIndex   int
+}
+
+func newSummary() summary {
+	return summary{classes: newClassReport(), maxConstantTerm: -1, maxCoefficient: -1}
+}
+
+func (s *summary) add(result Result) {
+	s.classes.add(result)
+	if !result.Halted {
+		s.nbNotHalting += 1
+		return
 	}
-	defer outputFile.Close()
 
-	// Not gonna add multithreading until it gets annoyingly slow 😤
+	if result.Exact {
+		s.nbExact += 1
+	}
 
-	for i := 0; i < databaseSize; i += 1 {
-		lba, error := bbc.GetMachineI(database, i, false)
-		if error != nil {
-			fmt.Println("Error: ", error)
-		}
-		fmt.Println("Machine", i)
-		fmt.Println(lba.ToAsciiTable(2))
+	if result.Constant > s.maxConstantTerm {
+		s.maxConstantTerm = result.Constant
+		s.constantChampionIndex = result.Index
+	}
+	if result.Coefficient > s.maxCoefficient {
+		s.maxCoefficient = result.Coefficient
+		s.linearChampionIndex = result.Index
+	}
+}
+
+func (s summary) print(tapeLength int) {
+	s.classes.print(tapeLength)
+	fmt.Printf("Exact cost functions: %d\n", s.nbExact)
+	if s.nbNotHalting > 0 {
+		fmt.Printf("Not halting on a tape of length %d: %d\n", tapeLength, s.nbNotHalting)
+	}
+	if s.maxCoefficient > 0 {
+		fmt.Printf("Max coefficient: %d (machine %d)\nMax constant term: %d (machine %d)\n", s.maxCoefficient, s.linearChampionIndex, s.maxConstantTerm, s.constantChampionIndex)
+	} else {
+		fmt.Println("No translated cyclers found")
+	}
+}
 
-		coefficient, constantTerm := calculateLinearCostFunction(lba, 13)
+// Fits the halting times of the machine over a range of tape lengths
+func analyseFit(index int, tm bbc.TM, nbStates byte, from int, to int, trace io.Writer) FitResult {
+	result := fitMachine(tm, from, to)
+	result.Index = index
+	result.TM = tm.ToText(nbStates, 2, bbc.DEFAULT_HALT_SYMBOL)
+
+	fmt.Fprintln(trace, "Machine", index, result.TM)
+	fmt.Fprintln(trace, "Tape lengths:", result.TapeLengths)
+	fmt.Fprintln(trace, "Steps:", result.Steps)
+	for _, fit := range result.Fits {
+		fmt.Fprintf(trace, "%s: %s (max residual %g, rms %g)\n", fit.Model, fit.Formula, fit.MaxResidual, fit.RMSResidual)
+	}
+	fmt.Fprintln(trace)
+	return result
+}
 
-		// Create string for the cost function
-		costFunction := ""
-		if coefficient > 1 {
-			costFunction += fmt.Sprintf("%d", coefficient)
-		}
-		if coefficient > 0 {
-			costFunction += "t"
-			if constantTerm > 0 {
-				costFunction += " + "
-			}
+// Counts of the best fits
+type fitSummary struct {
+	nbFitting                 [MODEL_EXPONENTIAL + 1]int
+	nbInteresting, nbUnfitted int
+}
+
+func (s *fitSummary) add(result FitResult) {
+	switch {
+	case result.Best == nil:
+		s.nbUnfitted += 1
+	case result.Interesting:
+		s.nbInteresting += 1
+		fmt.Printf("Interesting: machine %d %s (best fit %s: %s, max residual %g)\n",
+			result.Index, result.TM, result.Best.Model, result.Best.Formula, result.Best.MaxResidual)
+	default:
+		s.nbFitting[result.Best.Model] += 1
+	}
+}
+
+func (s fitSummary) print() {
+	for model := MODEL_LINEAR; model <= MODEL_EXPONENTIAL; model += 1 {
+		fmt.Printf("%s: %d\n", model, s.nbFitting[model])
+	}
+	fmt.Println("Interesting (no model fits):", s.nbInteresting)
+	if s.nbUnfitted > 0 {
+		fmt.Println("Halting on too few tape lengths to fit:", s.nbUnfitted)
+	}
+}
+
+func main() {
+	arg_database := flag.String("db", "./run_2025-03-06_13-46-57_halting", "database or run file (header-less 30-byte machines) containing the halting machines")
+	arg_tape_length := flag.Int("slim", 13, "length of the tape the machines are run on")
+	arg_output := flag.String("o", "", "results file, one JSON line per machine (default output/<run name>.jsonl)")
+	arg_verbose := flag.Bool("v", false, "print the machines and the details of the search for translated cycles")
+	arg_fit := flag.String("fit", "", "range of tape lengths a:b, instead of looking for translated cycles at tape length -slim run each machine on all these tape lengths and fit linear, quadratic and exponential models to its halting times (at least 5 tape lengths)")
+	arg_workers := flag.Int("workers", 0, "number of go routines analysing machines in parallel (default GOMAXPROCS, i.e. the number of CPUs)")
+	arg_progress_freq := flag.Int("pf", 10, "seconds between each progress line (0 to disable them)")
+	flag.Parse()
+
+	var fitFrom, fitTo int
+	if *arg_fit != "" {
+		var err error
+		fitFrom, fitTo, err = bbc.ParseTapeLengthRange(*arg_fit)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
 		}
-		if constantTerm > 0 {
-			costFunction += fmt.Sprintf("%d", constantTerm)
+		if fitTo-fitFrom+1 < 5 {
+			fmt.Println("Fitting needs at least 5 tape lengths.")
+			os.Exit(-1)
 		}
+	}
+
+	tapeLength := *arg_tape_length
+	if tapeLength < 1 {
+		fmt.Println("The tape length must be at least 1.")
+		os.Exit(-1)
+	}
+
+	nbWorkers := *arg_workers
+	if nbWorkers < 0 {
+		fmt.Println("The number of workers must be positive.")
+		os.Exit(-1)
+	}
+	if nbWorkers == 0 {
+		nbWorkers = runtime.GOMAXPROCS(0)
+	}
+
+	if *arg_progress_freq < 0 {
+		fmt.Println("The progress frequency must be positive.")
+		os.Exit(-1)
+	}
+	progressFreq := time.Duration(*arg_progress_freq) * time.Second
+
+	outputPath := *arg_output
+	if outputPath == "" {
+		outputPath = "output/" + bbc.GetRunName() + ".jsonl"
+	}
 
-		fmt.Println()
-		fmt.Println("Cost function:", costFunction)
+	var trace io.Writer = ioutil.Discard
+	if *arg_verbose {
+		trace = os.Stdout
+	}
 
-		if constantTerm > maxConstantTerm {
-			maxConstantTerm = constantTerm
-			constantChampionIndex = uint32(i)
+	db, err := bbc.OpenDatabaseOrRunFile(*arg_database)
+	if err != nil {
+		fmt.Println("Cannot read the machines:", err)
+		os.Exit(-1)
+	}
+	defer db.Close()
+
+	// The decider runs 2-symbol machines whose head stays put on the edges of
+	// the tape, on tapes of length -slim unless fitting
+	if db.Header.Version > 0 {
+		expected := db.Header.Parameters
+		expected.NbSymbols = 2
+		expected.Boundary = bbc.BOUNDARY_STAY
+		if *arg_fit == "" {
+			expected.LimitSpace = tapeLength
 		}
-		if coefficient > maxCoefficient {
-			maxCoefficient = coefficient
-			linearChampionIndex = uint32(i)
+		if err := db.CheckParameters(expected); err != nil {
+			fmt.Println("The decider cannot analyse these machines:", err)
+			os.Exit(-1)
 		}
+	}
 
-		var toWrite [4]byte
-		binary.BigEndian.PutUint32(toWrite[0:4], uint32(i))
-		outputFile.Write(toWrite[:])
+	fmt.Println("Hi 🥺 :3")
+	if *arg_fit != "" {
+		fmt.Printf("%d machines, tape lengths %d to %d, %d workers\n", db.Len(), fitFrom, fitTo, nbWorkers)
+	} else {
+		fmt.Printf("%d machines, tape length %d, %d workers\n", db.Len(), tapeLength, nbWorkers)
+	}
 
-		fmt.Println()
-		fmt.Println("----------------------------------------")
-		fmt.Println()
+	// Create output file
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
 	}
+	defer outputFile.Close()
+	output := bufio.NewWriter(outputFile)
+
+	// It got annoyingly slow 😤
+	var analyse func(index int, tm bbc.TM, trace io.Writer) interface{}
+	var consume func(result interface{})
+	var printSummary func()
 
-	if maxCoefficient > 0 {
-		fmt.Printf("Max coefficient: %d (machine %d)\nMax constant term: %d (machine %d)\n", maxCoefficient, linearChampionIndex, maxConstantTerm, constantChampionIndex)
+	if *arg_fit != "" {
+		var s fitSummary
+		analyse = func(index int, tm bbc.TM, trace io.Writer) interface{} {
+			return analyseFit(index, tm, machineNbStates(db, tm), fitFrom, fitTo, trace)
+		}
+		consume = func(result interface{}) {
+			s.add(result.(FitResult))
+			writeResult(output, result)
+		}
+		printSummary = func() { s.print() }
 	} else {
-		fmt.Println("No translated cyclers found")
+		s := newSummary()
+		analyse = func(index int, tm bbc.TM, trace io.Writer) interface{} {
+			return analyseMachine(index, tm, machineNbStates(db, tm), tapeLength, trace)
+		}
+		consume = func(result interface{}) {
+			s.add(result.(Result))
+			writeResult(output, result)
+		}
+		printSummary = func() { s.print(tapeLength) }
 	}
+
+	if err := processMachines(db, nbWorkers, trace, progressFreq, analyse, consume); err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	if err := output.Flush(); err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	printSummary()
+	fmt.Println("Results written to", outputPath)
 }
@@ -0,0 +1,107 @@
+// Here we analyse the machines of a database in parallel, the results being
+// consumed in the order of the database so that the output does not depend on
+// the number of workers
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	bbc "github.com/bbchallenge/bbchallenge/lib_bbchallenge"
+)
+
+// Maximal number of machines being analysed or waiting for the ones before
+// them to be consumed, per worker
+const MACHINES_IN_FLIGHT_PER_WORKER = 64
+
+type machineJob struct {
+	index int
+	tm    bbc.TM
+}
+
+type machineDone struct {
+	index  int
+	result interface{}
+	trace  *bytes.Buffer
+}
+
+// Calls analyse on each machine of the database from nbWorkers go routines,
+// and consume on each result in the order of the database from the calling go
+// routine. What analyse writes to its trace is written to trace just before
+// its result is consumed, it is not kept if trace is ioutil.Discard.
+// A progress line is printed every progressFreq (never if 0).
+func processMachines(db *bbc.Database, nbWorkers int, trace io.Writer, progressFreq time.Duration,
+	analyse func(index int, tm bbc.TM, trace io.Writer) interface{},
+	consume func(result interface{})) error {
+
+	window := make(chan bool, nbWorkers*MACHINES_IN_FLIGHT_PER_WORKER)
+	jobs := make(chan machineJob)
+	results := make(chan machineDone)
+
+	var iterateErr error
+	go func() {
+		it := db.Iterate(0, db.Len())
+		for it.Next() {
+			window <- true
+			jobs <- machineJob{it.Index(), it.TM()}
+		}
+		iterateErr = it.Err()
+		close(jobs)
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < nbWorkers; w += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				var machineTrace *bytes.Buffer
+				var machineWriter io.Writer = ioutil.Discard
+				if trace != ioutil.Discard {
+					machineTrace = new(bytes.Buffer)
+					machineWriter = machineTrace
+				}
+				results <- machineDone{job.index, analyse(job.index, job.tm, machineWriter), machineTrace}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	timeStart := time.Now()
+	lastProgress := timeStart
+	pending := make(map[int]machineDone)
+	next := 0
+	for done := range results {
+		pending[done.index] = done
+		for {
+			done, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			if done.trace != nil {
+				trace.Write(done.trace.Bytes())
+			}
+			consume(done.result)
+			next += 1
+			<-window
+		}
+
+		if progressFreq > 0 && time.Since(lastProgress) >= progressFreq {
+			lastProgress = time.Now()
+			fmt.Printf("Processed %d / %d machines (%.0f machines/s)\n",
+				next, db.Len(), float64(next)/time.Since(timeStart).Seconds())
+		}
+	}
+
+	// All the go routines are done, the iterator's error has been set
+	return iterateErr
+}
@@ -0,0 +1,56 @@
+// Here we test that the results do not depend on the number of workers
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	bbc "github.com/bbchallenge/bbchallenge/lib_bbchallenge"
+)
+
+// Results file and trace of the decider on the sample run file
+func processSample(t *testing.T, nbWorkers int) (string, string) {
+	db, err := bbc.OpenRunFile("run_2025-03-06_13-46-57_halting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var output, trace bytes.Buffer
+	next := 0
+	err = processMachines(db, nbWorkers, &trace, 0,
+		func(index int, tm bbc.TM, trace io.Writer) interface{} {
+			return analyseMachine(index, tm, machineNbStates(db, tm), 13, trace)
+		},
+		func(result interface{}) {
+			if index := result.(Result).Index; index != next {
+				t.Fatalf("machine %d consumed instead of %d", index, next)
+			}
+			next += 1
+			asJson, _ := json.Marshal(result)
+			fmt.Fprintln(&output, string(asJson))
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != db.Len() {
+		t.Fatalf("%d machines consumed out of %d", next, db.Len())
+	}
+	return output.String(), trace.String()
+}
+
+func TestProcessMachinesDeterministic(t *testing.T) {
+	output, trace := processSample(t, 1)
+	for _, nbWorkers := range []int{2, 8} {
+		parallelOutput, parallelTrace := processSample(t, nbWorkers)
+		if parallelOutput != output {
+			t.Errorf("results with %d workers differ from the results with 1 worker", nbWorkers)
+		}
+		if parallelTrace != trace {
+			t.Errorf("trace with %d workers differs from the trace with 1 worker", nbWorkers)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Here we run the coordinator and the workers of a distributed enumeration,
+// see lib_bbchallenge/distributed.go for the protocol
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+
+	bbc "github.com/bbchallenge/bbchallenge/lib_bbchallenge"
+	log "github.com/sirupsen/logrus"
+)
+
+// Workers ask again for a task every 5 seconds when none is available, the
+// coordinator keeps answering for a while once the run is over so that they
+// learn it is
+const COORDINATOR_GRACE_PERIOD = 15 * time.Second
+
+func coordinate(params bbc.RunParameters, runName string, addr string, lease time.Duration) {
+	mainLogFile := bbc.InitAppendFile(runName+".txt", "output/")
+	log.SetFormatter(new(BBChallengeFormatter))
+	log.SetOutput(mainLogFile)
+
+	tasksDir := "output/" + runName + "_tasks"
+	if err := os.MkdirAll(tasksDir, 0755); err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	coordinator := bbc.NewCoordinator(params, tasksDir)
+	coordinator.LeaseDuration = lease
+	coordinator.Log = io.MultiWriter(os.Stdout, mainLogFile)
+
+	log.Info(runName)
+	log.Info(time.Now().Format(time.RFC1123))
+	log.Info("Coordinator listening on ", addr)
+	log.Info("Nb states: ", params.NbStates)
+	log.Info("Nb symbols: ", params.NbSymbols)
+	log.Info("Task divisor: ", params.TaskDivisor)
+	log.Info("Task depth: ", params.TaskDepth)
+	log.Info("Limit time: ", params.LimitTime)
+	log.Info("Limit space: ", params.LimitSpace)
+	log.Info("Boundary: ", params.Boundary)
+	log.Info("Record format: ", params.Format)
+	log.Info("Lease: ", lease)
+
+	server := &http.Server{Addr: addr, Handler: coordinator}
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+	}()
+
+	timeStart := time.Now()
+	<-coordinator.Done()
+	runTime := time.Since(timeStart)
+
+	extension := recordFileExtension(params.Format)
+	for _, kind := range bbc.ResultKinds {
+		file := bbc.InitAppendFile(runName+"_"+kind+extension, "output/")
+		err := coordinator.WriteResults(kind, file)
+		file.Close()
+		if err != nil {
+			fmt.Println("Cannot write the", kind, "results:", err)
+			os.Exit(-1)
+		}
+	}
+	os.RemoveAll(tasksDir)
+	writeRunHeader(runName, params, coordinator.Metrics())
+
+	log.Infoln("\nReport")
+	log.Infoln("======")
+	log.Info("Run time: ", runTime, "\n")
+	logMetrics(params.NbStates, coordinator.Metrics())
+	mainLogFile.Close()
+
+	time.Sleep(COORDINATOR_GRACE_PERIOD)
+	server.Close()
+}
+
+func distributedWorker(coordinatorURL string, name string, nbWorkers int) {
+	if name == "" {
+		hostname, _ := os.Hostname()
+		name = hostname + "-" + strconv.Itoa(os.Getpid())
+	}
+	if nbWorkers <= 0 {
+		nbWorkers = runtime.GOMAXPROCS(0)
+	}
+
+	worker := bbc.NewDistributedWorker(coordinatorURL, name)
+	worker.NbWorkers = nbWorkers
+	worker.Log = os.Stdout
+
+	nbTasks, err := worker.Run()
+	if err != nil {
+		fmt.Println("Worker stopped after", nbTasks, "tasks:", err)
+		os.Exit(-1)
+	}
+	fmt.Println("No more tasks, worker done after", nbTasks, "tasks")
+}
@@ -0,0 +1,76 @@
+// Here we simulate or debug a single machine given in text notation, see
+// lib_bbchallenge/inspect.go and lib_bbchallenge/debugger.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	bbc "github.com/bbchallenge/bbchallenge/lib_bbchallenge"
+)
+
+// Pixels per cell in PNG and SVG diagrams
+const DIAGRAM_CELL_SIZE = 4
+
+func parseMachineArg(args []string, subcommand string, haltSymbol string) (bbc.TM, byte, byte) {
+	if len(args) != 1 {
+		fmt.Println("Give the machine in text notation, e.g. '" + subcommand + " -slim 13 1RB1LC_1RC1RB_1RD0LE_1LA1LD_1RZ0LA'.")
+		os.Exit(-1)
+	}
+
+	tm, nbStates, nbSymbols, err := bbc.ParseTM(args[0], parseHaltSymbol(haltSymbol))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	return tm, nbStates, nbSymbols
+}
+
+func simulateMachine(args []string, haltSymbol string, limitTime int, limitSpace int, boundary bbc.BoundaryMode, diagram string, maxRows int) {
+	tm, nbStates, nbSymbols := parseMachineArg(args, "simulate", haltSymbol)
+
+	if diagram == "" {
+		maxRows = 0
+	}
+	simulation := bbc.Simulate(tm, nbStates, nbSymbols, limitTime, limitSpace, boundary, maxRows)
+
+	if err := simulation.WriteText(os.Stdout, diagram == "ascii"); err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	if diagram == "" || diagram == "ascii" {
+		return
+	}
+
+	file, err := os.Create(diagram)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	if strings.HasSuffix(diagram, ".svg") {
+		err = simulation.WriteDiagramSVG(file, DIAGRAM_CELL_SIZE)
+	} else {
+		err = simulation.WriteDiagramPNG(file, DIAGRAM_CELL_SIZE)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Println("Cannot write the diagram:", err)
+		os.Exit(-1)
+	}
+	fmt.Println("Space-time diagram written to", diagram)
+}
+
+func debugMachine(args []string, haltSymbol string, limitSpace int, boundary bbc.BoundaryMode) {
+	tm, nbStates, nbSymbols := parseMachineArg(args, "debug", haltSymbol)
+
+	fmt.Println("Type 'help' for the list of commands.")
+	debugger := bbc.NewDebugger(tm, nbStates, nbSymbols, limitSpace, boundary)
+	if err := debugger.REPL(os.Stdin, os.Stdout, "(debug) "); err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+}
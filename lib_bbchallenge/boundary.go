@@ -0,0 +1,87 @@
+// Here we define what happens when an LBA's head reaches the edges of its tape
+package bbchallenge
+
+import (
+	"errors"
+	"math"
+	"strings"
+)
+
+type BoundaryMode byte
+
+const (
+	// The head stays in place when it tries to move past an edge
+	BOUNDARY_STAY BoundaryMode = iota
+	// The machine halts when it tries to move past an edge
+	BOUNDARY_HALT
+	// The machine rejects, i.e. is considered to loop forever, when it tries
+	// to move past an edge
+	BOUNDARY_REJECT
+	// The tape is circular: moving past an edge brings the head to the other one
+	BOUNDARY_WRAP
+)
+
+var boundaryModeNames = []string{"stay", "halt", "reject", "wrap"}
+
+func (b BoundaryMode) String() string {
+	if int(b) < len(boundaryModeNames) {
+		return boundaryModeNames[b]
+	}
+	return "unknown"
+}
+
+func ParseBoundaryMode(name string) (BoundaryMode, error) {
+	for i, boundaryName := range boundaryModeNames {
+		if name == boundaryName {
+			return BoundaryMode(i), nil
+		}
+	}
+	return BOUNDARY_STAY, errors.New("unknown boundary mode '" + name +
+		"', must be one of: " + strings.Join(boundaryModeNames, ", "))
+}
+
+// Boundary modes are saved by name in checkpoints
+func (b BoundaryMode) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+func (b *BoundaryMode) UnmarshalText(text []byte) (err error) {
+	*b, err = ParseBoundaryMode(string(text))
+	return err
+}
+
+// Returns the number of configurations of an LBA: a machine that runs for
+// more steps than that has repeated a configuration and will never halt,
+// whatever the boundary mode.
+// Saturates at math.MaxInt.
+func UpperBound(nbStates byte, nbSymbols byte, limitSpace int) int {
+	bound := limitSpace * int(nbStates)
+	for i := 0; i < limitSpace; i += 1 {
+		if bound > math.MaxInt/int(nbSymbols) {
+			return math.MaxInt
+		}
+		bound *= int(nbSymbols)
+	}
+	return bound
+}
+
+// Moves the head according to the boundary mode.
+// Returns the new head position and whether the head tried to move past an
+// edge of the tape. Halting and rejecting at the edges are left to the caller.
+func moveHead(head int, move byte, limitSpace int, boundary BoundaryMode) (int, bool) {
+	if move == R {
+		head += 1
+	} else {
+		head -= 1
+	}
+
+	if head >= 0 && head < limitSpace {
+		return head, false
+	}
+
+	if boundary == BOUNDARY_WRAP {
+		return (head + limitSpace) % limitSpace, true
+	}
+
+	return MinI(MaxI(head, 0), limitSpace-1), true
+}
@@ -0,0 +1,337 @@
+// Here we checkpoint long enumeration runs so that they can be resumed
+package bbchallenge
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Progress is recorded per subtree of the enumeration tree: a subtree is
+// identified by its root, a machine with exactly CheckpointDepth defined
+// transitions, and contains that machine and all its descendants.
+// Records of a subtree are held back and only sent to the sinks once the
+// whole subtree has been enumerated, so that the files can be truncated
+// back to a consistent state when resuming. The first ones are kept in
+// memory and the others are spilled to a temporary file next to the
+// checkpoint.
+// Machines above the checkpoint depth are cheap to simulate and are simply
+// enumerated again on resume, their records are sent to the sinks right away
+// and are not written again on resume.
+
+type Checkpoint struct {
+	Parameters RunParameters
+	Time       string
+
+	// Roots of the completed subtrees, hex encoded
+	Completed []string
+	// Machines above the checkpoint depth whose records were written, hex
+	// encoded
+	Shallow []string
+	// Metrics of the completed subtrees
+	Metrics Metrics
+
+	// Number of bytes written by the sinks, anything beyond was written after
+	// the checkpoint and must be discarded when resuming
+	HaltingSize        int64
+	UndecidedTimeSize  int64
+	UndecidedSpaceSize int64
+
+	Finished bool
+}
+
+// Number of records of a subtree kept in memory, the next ones are spilled to
+// a temporary file until the subtree is completed
+const SUBTREE_MAX_BUFFERED_RECORDS = 1 << 14
+
+// A subtree being enumerated
+type subtree struct {
+	nbPendingTasks int64 // Accessed atomically, see scheduler.go
+
+	root    TM
+	mutex   sync.Mutex
+	metrics Metrics
+
+	records []Record
+	// Records beyond the buffered ones, in the extended format
+	spill       *os.File
+	spillWriter *bufio.Writer
+	err         error // First error met while spilling records
+}
+
+func newSubtree(root TM) *subtree {
+	return &subtree{root: root}
+}
+
+func (e *Enumerator) writeToSubtree(s *subtree, record Record) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.records) < e.checkpoint.maxBufferedRecords {
+		s.records = append(s.records, record)
+		return
+	}
+
+	if s.err != nil {
+		return
+	}
+	if s.spill == nil {
+		s.spill, s.err = ioutil.TempFile(filepath.Dir(e.CheckpointPath), filepath.Base(e.CheckpointPath)+".subtree-*")
+		if s.err != nil {
+			return
+		}
+		s.spillWriter = bufio.NewWriter(s.spill)
+	}
+	_, s.err = s.spillWriter.Write(EncodeExtendedRecord(record))
+}
+
+// Calls f on each record of the completed subtree, in the order they were
+// written, then releases them
+func (s *subtree) forEachRecord(f func(record Record) error) (err error) {
+	defer func() {
+		s.records = nil
+		if s.spill != nil {
+			s.spill.Close()
+			os.Remove(s.spill.Name())
+			s.spill = nil
+		}
+	}()
+
+	for _, record := range s.records {
+		if err = f(record); err != nil {
+			return err
+		}
+	}
+
+	if s.err != nil || s.spill == nil {
+		return s.err
+	}
+	if err = s.spillWriter.Flush(); err != nil {
+		return err
+	}
+	if _, err = s.spill.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(s.spill)
+	buffer := make([]byte, EXTENDED_RECORD_SIZE)
+	for {
+		if _, err = io.ReadFull(reader, buffer); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		record, err := DecodeExtendedRecord(buffer)
+		if err != nil {
+			return err
+		}
+		if err = f(record); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *subtree) addMetrics(metrics Metrics) {
+	s.mutex.Lock()
+	s.metrics.add(metrics)
+	s.mutex.Unlock()
+}
+
+// Checkpointing state of an enumerator
+type checkpointState struct {
+	mutex              sync.Mutex
+	completedSubtrees  map[TM]bool
+	completedMetrics   Metrics
+	writtenShallow     map[TM]bool // Machines above the checkpoint depth whose records were written
+	haltingSize        int64
+	undecidedTimeSize  int64
+	undecidedSpaceSize int64
+	lastCheckpointTime time.Time
+
+	maxBufferedRecords int // SUBTREE_MAX_BUFFERED_RECORDS, lowered in tests
+}
+
+func newCheckpointState() *checkpointState {
+	return &checkpointState{
+		completedSubtrees:  make(map[TM]bool),
+		writtenShallow:     make(map[TM]bool),
+		lastCheckpointTime: time.Now(),
+		maxBufferedRecords: SUBTREE_MAX_BUFFERED_RECORDS,
+	}
+}
+
+func (e *Enumerator) isSubtreeCompleted(root TM) bool {
+	e.checkpoint.mutex.Lock()
+	defer e.checkpoint.mutex.Unlock()
+	return e.checkpoint.completedSubtrees[root]
+}
+
+// Sends a record to its sink and counts the bytes written, the checkpoint
+// mutex must be held
+func (e *Enumerator) writeToSink(record Record) error {
+	n, err := e.sink(record.HaltStatus).Write(record)
+
+	switch record.HaltStatus {
+	case HALT:
+		e.checkpoint.haltingSize += int64(n)
+	case UNDECIDED_TIME:
+		e.checkpoint.undecidedTimeSize += int64(n)
+	case UNDECIDED_SPACE:
+		e.checkpoint.undecidedSpaceSize += int64(n)
+	}
+	return err
+}
+
+// Logs errors which do not stop the enumeration
+func (e *Enumerator) logError(message string, err error) {
+	if err != nil {
+		fmt.Fprintln(e.ErrorLog, message+":", err)
+	}
+}
+
+func (e *Enumerator) completeSubtree(s *subtree) {
+	e.checkpoint.mutex.Lock()
+	defer e.checkpoint.mutex.Unlock()
+
+	e.logError("Cannot write the records of a subtree", s.forEachRecord(e.writeToSink))
+	e.checkpoint.completedSubtrees[s.root] = true
+	e.checkpoint.completedMetrics.add(s.metrics)
+
+	if time.Since(e.checkpoint.lastCheckpointTime) >= time.Duration(e.CheckpointFreq) {
+		e.logError("Cannot write checkpoint", e.writeCheckpoint(false))
+	}
+}
+
+// Writes a last checkpoint marking the run as finished
+func (e *Enumerator) finishCheckpoint() error {
+	e.checkpoint.mutex.Lock()
+	defer e.checkpoint.mutex.Unlock()
+
+	return e.writeCheckpoint(true)
+}
+
+func (e *Enumerator) writeCheckpoint(finished bool) error {
+	e.checkpoint.lastCheckpointTime = time.Now()
+
+	// The recorded sizes must be on disk before the checkpoint is
+	for _, sink := range []ResultSink{e.HaltingSink, e.UndecidedTimeSink, e.UndecidedSpaceSink} {
+		if err := SyncSink(sink); err != nil {
+			return err
+		}
+	}
+
+	checkpoint := Checkpoint{
+		Parameters:         e.RunParameters,
+		Time:               e.checkpoint.lastCheckpointTime.Format(time.RFC1123),
+		Metrics:            e.checkpoint.completedMetrics,
+		HaltingSize:        e.checkpoint.haltingSize,
+		UndecidedTimeSize:  e.checkpoint.undecidedTimeSize,
+		UndecidedSpaceSize: e.checkpoint.undecidedSpaceSize,
+		Finished:           finished,
+	}
+
+	for root := range e.checkpoint.completedSubtrees {
+		checkpoint.Completed = append(checkpoint.Completed, hex.EncodeToString(root[:]))
+	}
+	sort.Strings(checkpoint.Completed)
+
+	for tm := range e.checkpoint.writtenShallow {
+		checkpoint.Shallow = append(checkpoint.Shallow, hex.EncodeToString(tm[:]))
+	}
+	sort.Strings(checkpoint.Shallow)
+
+	asJson, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write then rename so that a crash never leaves a half written checkpoint
+	if err := ioutil.WriteFile(e.CheckpointPath+".tmp", asJson, 0644); err != nil {
+		return err
+	}
+	return os.Rename(e.CheckpointPath+".tmp", e.CheckpointPath)
+}
+
+func LoadCheckpoint(path string) (checkpoint Checkpoint, err error) {
+	asJson, err := ioutil.ReadFile(path)
+	if err != nil {
+		return checkpoint, err
+	}
+
+	err = json.Unmarshal(asJson, &checkpoint)
+	return checkpoint, err
+}
+
+// Restores the progress and metrics recorded in the checkpoint. The output
+// files must have been truncated to the sizes recorded in the checkpoint.
+func (e *Enumerator) ResumeFromCheckpoint(checkpoint Checkpoint) error {
+	e.checkpoint.mutex.Lock()
+	defer e.checkpoint.mutex.Unlock()
+
+	completedSubtrees, err := decodeCheckpointTMs(checkpoint.Completed)
+	if err != nil {
+		return err
+	}
+	writtenShallow, err := decodeCheckpointTMs(checkpoint.Shallow)
+	if err != nil {
+		return err
+	}
+
+	e.checkpoint.completedSubtrees = completedSubtrees
+	e.checkpoint.writtenShallow = writtenShallow
+	e.checkpoint.completedMetrics = checkpoint.Metrics
+	e.checkpoint.haltingSize = checkpoint.HaltingSize
+	e.checkpoint.undecidedTimeSize = checkpoint.UndecidedTimeSize
+	e.checkpoint.undecidedSpaceSize = checkpoint.UndecidedSpaceSize
+
+	e.metrics = checkpoint.Metrics
+
+	return nil
+}
+
+func decodeCheckpointTMs(hexTMs []string) (map[TM]bool, error) {
+	tms := make(map[TM]bool)
+	for _, tmHex := range hexTMs {
+		var tm TM
+		decoded, err := hex.DecodeString(tmHex)
+		if err != nil || len(decoded) != len(tm) {
+			return nil, errors.New("invalid machine in checkpoint: " + tmHex)
+		}
+		copy(tm[:], decoded)
+		tms[tm] = true
+	}
+	return tms, nil
+}
+
+// Sends the record of a machine to its subtree, or to the sinks
+// directly when it belongs to none
+func (e *Enumerator) writeRecord(sub *subtree, record Record) {
+	if sub != nil {
+		e.writeToSubtree(sub, record)
+		return
+	}
+
+	if e.CheckpointPath == "" {
+		_, err := e.sink(record.HaltStatus).Write(record)
+		e.logError("Cannot write record", err)
+		return
+	}
+
+	// Above the checkpoint depth, written once even if the run is resumed
+	e.checkpoint.mutex.Lock()
+	defer e.checkpoint.mutex.Unlock()
+	if !e.checkpoint.writtenShallow[record.TM] {
+		e.checkpoint.writtenShallow[record.TM] = true
+		e.logError("Cannot write record", e.writeToSink(record))
+	}
+}
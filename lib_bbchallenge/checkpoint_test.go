@@ -0,0 +1,81 @@
+// Here we test that checkpointed runs write the same records as plain ones
+package bbchallenge
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Enumerates with checkpoints in dir, subtrees keeping at most
+// maxBufferedRecords records in memory
+func enumerateCheckpointed(t *testing.T, dir string, maxBufferedRecords int) (Report, []byte, Checkpoint) {
+	params := getSmallRunParameters(3, 4, BOUNDARY_STAY)
+	params.CheckpointDepth = 3
+
+	var halting bytes.Buffer
+	e := NewEnumerator(params)
+	e.NbWorkers = 1
+	e.HaltingSink = ExtendedSink{&halting}
+	e.CheckpointPath = filepath.Join(dir, "checkpoint.json")
+	e.checkpoint.maxBufferedRecords = maxBufferedRecords
+	report := e.Enumerate()
+
+	checkpoint, err := LoadCheckpoint(e.CheckpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report, halting.Bytes(), checkpoint
+}
+
+// Records spilled to disk are written in the same order as buffered ones,
+// and their temporary files are removed
+func TestCheckpointSpill(t *testing.T) {
+	report, expected, _ := enumerateCheckpointed(t, t.TempDir(), SUBTREE_MAX_BUFFERED_RECORDS)
+
+	dir := t.TempDir()
+	spilledReport, spilled, checkpoint := enumerateCheckpointed(t, dir, 1)
+
+	if spilledReport.Metrics != report.Metrics {
+		t.Error(spilledReport.Metrics, report.Metrics)
+	}
+	if !bytes.Equal(spilled, expected) || checkpoint.HaltingSize != int64(len(spilled)) {
+		t.Error(len(spilled), len(expected), checkpoint.HaltingSize)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Error("temporary files left:", len(files)-1)
+	}
+}
+
+// Resuming a run whose subtrees are all completed writes nothing again,
+// not even the records of the machines above the checkpoint depth
+func TestCheckpointResumeShallow(t *testing.T) {
+	dir := t.TempDir()
+	report, _, checkpoint := enumerateCheckpointed(t, dir, SUBTREE_MAX_BUFFERED_RECORDS)
+	if len(checkpoint.Shallow) == 0 {
+		t.Fatal("no machine above the checkpoint depth")
+	}
+
+	var halting bytes.Buffer
+	e := NewEnumerator(checkpoint.Parameters)
+	e.NbWorkers = 1
+	e.HaltingSink = ExtendedSink{&halting}
+	e.CheckpointPath = filepath.Join(dir, "checkpoint.json")
+	if err := e.ResumeFromCheckpoint(checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	resumed := e.Enumerate()
+
+	if halting.Len() != 0 {
+		t.Error(halting.Len())
+	}
+	if resumed.Metrics != report.Metrics {
+		t.Error(resumed.Metrics, report.Metrics)
+	}
+}
@@ -0,0 +1,616 @@
+// Here we assemble the database of undecided machines from the outputs of
+// enumeration runs and read it back
+package bbchallenge
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// The database starts with a 30-byte header:
+//   - 4 bytes: number of machines that exceeded the time limit
+//   - 4 bytes: number of machines that exceeded the space limit
+//   - 4 bytes: total number of machines
+//   - 1 byte: 1 if each section is lexicographically sorted
+//   - 1 byte: version of the header
+//
+// Version 0 headers (the seed database) stop there and the rest is zeros.
+// Version 1 headers record the parameters of the enumeration:
+//   - 1 byte: number of states
+//   - 1 byte: number of symbols
+//   - 1 byte: boundary mode (see boundary.go)
+//   - 1 byte: flags, 1 if redundant machines were pruned, 2 if cycles were
+//     detected
+//   - 4 bytes: tape length (space limit)
+//   - 8 bytes: time limit
+//
+// Integers are big-endian. The header is followed by the 30-byte machines
+// (see LegacySink): first the machines that exceeded the time limit, then
+// those that exceeded the space limit.
+const DB_HEADER_SIZE = 30
+
+const DB_HEADER_VERSION = 1
+
+const (
+	dbFlagFiltering    = 1
+	dbFlagDetectCycles = 2
+)
+
+type DatabaseHeader struct {
+	NbUndecidedTime  int
+	NbUndecidedSpace int
+	NbMachines       int
+	Sorted           bool
+
+	// Parameters of the enumeration, only known from version 1
+	Version    byte
+	Parameters DatabaseParameters
+}
+
+// Parameters of the enumeration which produced the machines of a database
+type DatabaseParameters struct {
+	NbStates          byte
+	NbSymbols         byte
+	LimitTime         int
+	LimitSpace        int
+	Boundary          BoundaryMode
+	ActivateFiltering bool
+	DetectCycles      bool
+}
+
+func (params RunParameters) DatabaseParameters() DatabaseParameters {
+	return DatabaseParameters{
+		NbStates:          params.NbStates,
+		NbSymbols:         params.NbSymbols,
+		LimitTime:         params.LimitTime,
+		LimitSpace:        params.LimitSpace,
+		Boundary:          params.Boundary,
+		ActivateFiltering: params.ActivateFiltering,
+		DetectCycles:      params.DetectCycles,
+	}
+}
+
+// Header of the undecided machines of a run, which can be merged in a
+// database (see MergeDatabase)
+func (params RunParameters) DatabaseHeader(metrics Metrics) DatabaseHeader {
+	return DatabaseHeader{
+		NbUndecidedTime:  metrics.NbUndecidedTime,
+		NbUndecidedSpace: metrics.NbUndecidedSpace,
+		NbMachines:       metrics.NbUndecidedTime + metrics.NbUndecidedSpace,
+		Version:          DB_HEADER_VERSION,
+		Parameters:       params.DatabaseParameters(),
+	}
+}
+
+func (h DatabaseHeader) Encode() []byte {
+	var buffer [DB_HEADER_SIZE]byte
+	binary.BigEndian.PutUint32(buffer[0:4], uint32(h.NbUndecidedTime))
+	binary.BigEndian.PutUint32(buffer[4:8], uint32(h.NbUndecidedSpace))
+	binary.BigEndian.PutUint32(buffer[8:12], uint32(h.NbMachines))
+	if h.Sorted {
+		buffer[12] = 1
+	}
+
+	buffer[13] = h.Version
+	if h.Version == 0 {
+		return buffer[:]
+	}
+
+	params := h.Parameters
+	buffer[14] = params.NbStates
+	buffer[15] = params.NbSymbols
+	buffer[16] = byte(params.Boundary)
+	if params.ActivateFiltering {
+		buffer[17] |= dbFlagFiltering
+	}
+	if params.DetectCycles {
+		buffer[17] |= dbFlagDetectCycles
+	}
+	binary.BigEndian.PutUint32(buffer[18:22], uint32(params.LimitSpace))
+	binary.BigEndian.PutUint64(buffer[22:30], uint64(params.LimitTime))
+	return buffer[:]
+}
+
+func DecodeDatabaseHeader(buffer []byte) (h DatabaseHeader, err error) {
+	if len(buffer) < DB_HEADER_SIZE {
+		return h, errors.New("database header is too short")
+	}
+
+	h.NbUndecidedTime = int(binary.BigEndian.Uint32(buffer[0:4]))
+	h.NbUndecidedSpace = int(binary.BigEndian.Uint32(buffer[4:8]))
+	h.NbMachines = int(binary.BigEndian.Uint32(buffer[8:12]))
+	h.Sorted = buffer[12] == 1
+
+	if h.NbMachines != h.NbUndecidedTime+h.NbUndecidedSpace {
+		return h, fmt.Errorf("invalid database header: %d machines is not %d + %d",
+			h.NbMachines, h.NbUndecidedTime, h.NbUndecidedSpace)
+	}
+
+	h.Version = buffer[13]
+	switch h.Version {
+	case 0:
+		return h, nil
+	case 1:
+	default:
+		return h, fmt.Errorf("unsupported database header version %d", h.Version)
+	}
+
+	params := &h.Parameters
+	params.NbStates = buffer[14]
+	params.NbSymbols = buffer[15]
+	params.Boundary = BoundaryMode(buffer[16])
+	params.ActivateFiltering = buffer[17]&dbFlagFiltering != 0
+	params.DetectCycles = buffer[17]&dbFlagDetectCycles != 0
+	params.LimitSpace = int(binary.BigEndian.Uint32(buffer[18:22]))
+	params.LimitTime = int(binary.BigEndian.Uint64(buffer[22:30]))
+
+	// The database only holds 30-byte machines
+	if params.NbStates < 1 || params.NbStates > 5 || params.NbSymbols != 2 ||
+		int(params.Boundary) >= len(boundaryModeNames) || buffer[17] > dbFlagFiltering|dbFlagDetectCycles ||
+		params.LimitSpace < 1 || params.LimitTime < 1 {
+		return h, errors.New("invalid enumeration parameters in database header")
+	}
+	return h, nil
+}
+
+// Parameters shared by the runs whose headers are given, to be written in
+// the header of the database merging them. Runs without parameters (version
+// 0 headers) can only be merged together.
+func MergeHeaderParameters(headers []DatabaseHeader) (h DatabaseHeader, err error) {
+	for i, other := range headers {
+		if i == 0 {
+			h.Version, h.Parameters = other.Version, other.Parameters
+			continue
+		}
+		if other.Version != h.Version || other.Parameters != h.Parameters {
+			return h, fmt.Errorf("runs 0 and %d have different enumeration parameters: %+v and %+v",
+				i, h.Parameters, other.Parameters)
+		}
+	}
+	return h, nil
+}
+
+// Version of RunHeader, any change to its fields must come with a new version
+const RUN_HEADER_VERSION = 1
+
+// Parameters and counts of the output files of a run, written as JSON next to
+// them whatever their record format. The binary header of the database only
+// describes legacy files, i.e. <= 5-state 2-symbol machines.
+type RunHeader struct {
+	Version     int
+	Format      RecordFormat
+	Parameters  DatabaseParameters
+	TaskDivisor int // The files only hold the machines of MyTasks when > 1
+	MyTasks     []int
+	Metrics     Metrics
+}
+
+func (params RunParameters) RunHeader(metrics Metrics) RunHeader {
+	return RunHeader{
+		Version:     RUN_HEADER_VERSION,
+		Format:      params.Format,
+		Parameters:  params.DatabaseParameters(),
+		TaskDivisor: params.TaskDivisor,
+		MyTasks:     params.MyTasks,
+		Metrics:     metrics,
+	}
+}
+
+func WriteRunHeader(path string, h RunHeader) error {
+	asJson, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, asJson, 0644)
+}
+
+func LoadRunHeader(path string) (h RunHeader, err error) {
+	asJson, err := ioutil.ReadFile(path)
+	if err != nil {
+		return h, err
+	}
+
+	if err = json.Unmarshal(asJson, &h); err != nil {
+		return h, err
+	}
+	if h.Version != RUN_HEADER_VERSION {
+		return h, fmt.Errorf("unsupported run header version %d", h.Version)
+	}
+	return h, nil
+}
+
+// Machines of a section of the database, in a single buffer
+type legacyMachines []byte
+
+func (m legacyMachines) Len() int {
+	return len(m) / LEGACY_TM_SIZE
+}
+
+func (m legacyMachines) machine(i int) []byte {
+	return m[i*LEGACY_TM_SIZE : (i+1)*LEGACY_TM_SIZE]
+}
+
+func (m legacyMachines) Less(i, j int) bool {
+	return bytes.Compare(m.machine(i), m.machine(j)) < 0
+}
+
+func (m legacyMachines) Swap(i, j int) {
+	var tmp [LEGACY_TM_SIZE]byte
+	copy(tmp[:], m.machine(i))
+	copy(m.machine(i), m.machine(j))
+	copy(m.machine(j), tmp[:])
+}
+
+// Sorts the machines and removes duplicates, returns the deduplicated
+// machines and the number of removed duplicates
+func (m legacyMachines) sortAndDedup() (legacyMachines, int) {
+	sort.Sort(m)
+
+	n := 0
+	for i := 0; i < m.Len(); i += 1 {
+		if n > 0 && bytes.Equal(m.machine(i), m.machine(n-1)) {
+			continue
+		}
+		copy(m.machine(n), m.machine(i))
+		n += 1
+	}
+	return m[:n*LEGACY_TM_SIZE], m.Len() - n
+}
+
+func readLegacyMachines(paths []string) (legacyMachines, error) {
+	var machines legacyMachines
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(content)%LEGACY_TM_SIZE != 0 {
+			return nil, fmt.Errorf("%s is not made of %d-byte machines", path, LEGACY_TM_SIZE)
+		}
+		machines = append(machines, content...)
+	}
+	return machines, nil
+}
+
+// Outcome of MergeDatabase
+type MergeReport struct {
+	Header                    DatabaseHeader
+	NbDuplicateUndecidedTime  int
+	NbDuplicateUndecidedSpace int
+}
+
+// Writes the database made of the machines of the given undecided time and
+// undecided space files (in the legacy format). Each section is sorted and
+// deduplicated. The version and parameters of the header are those of the
+// given header (see MergeHeaderParameters). All machines are loaded in memory.
+func MergeDatabase(w io.Writer, header DatabaseHeader, undecidedTimePaths []string, undecidedSpacePaths []string) (report MergeReport, err error) {
+	undecidedTime, err := readLegacyMachines(undecidedTimePaths)
+	if err != nil {
+		return report, err
+	}
+	undecidedSpace, err := readLegacyMachines(undecidedSpacePaths)
+	if err != nil {
+		return report, err
+	}
+
+	undecidedTime, report.NbDuplicateUndecidedTime = undecidedTime.sortAndDedup()
+	undecidedSpace, report.NbDuplicateUndecidedSpace = undecidedSpace.sortAndDedup()
+
+	report.Header = header
+	report.Header.NbUndecidedTime = undecidedTime.Len()
+	report.Header.NbUndecidedSpace = undecidedSpace.Len()
+	report.Header.NbMachines = undecidedTime.Len() + undecidedSpace.Len()
+	report.Header.Sorted = true
+
+	for _, part := range [][]byte{report.Header.Encode(), undecidedTime, undecidedSpace} {
+		if _, err := w.Write(part); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// Checks that the size of the database matches its header and, if it is
+// sorted, that each section is sorted without duplicates
+func VerifyDatabase(r io.Reader) (h DatabaseHeader, err error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return h, err
+	}
+
+	h, err = DecodeDatabaseHeader(content)
+	if err != nil {
+		return h, err
+	}
+
+	machines := legacyMachines(content[DB_HEADER_SIZE:])
+	if len(machines) != h.NbMachines*LEGACY_TM_SIZE {
+		return h, fmt.Errorf("the header announces %d machines but the database holds %d bytes of machines",
+			h.NbMachines, len(machines))
+	}
+
+	if !h.Sorted {
+		return h, nil
+	}
+
+	sections := []legacyMachines{
+		machines[:h.NbUndecidedTime*LEGACY_TM_SIZE],
+		machines[h.NbUndecidedTime*LEGACY_TM_SIZE:],
+	}
+	for _, section := range sections {
+		for i := 1; i < section.Len(); i += 1 {
+			if bytes.Compare(section.machine(i-1), section.machine(i)) >= 0 {
+				return h, fmt.Errorf("machine %d of a section is not strictly greater than the previous one", i)
+			}
+		}
+	}
+	return h, nil
+}
+
+// Random and sequential access to the machines of a database, or of a
+// header-less run file such as `_halting` (legacy format). Machines are read
+// from the file on demand and a Database can be used by several goroutines.
+type Database struct {
+	Header    DatabaseHeader
+	HasHeader bool
+	file      *os.File
+}
+
+func openDatabase(path string, hasHeader bool) (*Database, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &Database{HasHeader: hasHeader, file: file}
+	if err := db.readHeader(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return db, nil
+}
+
+// Opens a database starting with a 30-byte header
+func OpenDatabase(path string) (*Database, error) {
+	return openDatabase(path, true)
+}
+
+// Opens a header-less file of 30-byte machines, such as the outputs of a run
+func OpenRunFile(path string) (*Database, error) {
+	return openDatabase(path, false)
+}
+
+// Opens a database, or a header-less run file if the file does not start
+// with a header. A file whose first 30 bytes are not a machine is not read as
+// a run file, so that a database whose header is invalid or of an unsupported
+// version is not opened with its header as machine 0. Version 1 headers are
+// never machines, as their number of symbols is where the first symbol
+// written by machine 0 would be.
+func OpenDatabaseOrRunFile(path string) (*Database, error) {
+	db, err := OpenDatabase(path)
+	if err == nil {
+		return db, nil
+	}
+
+	run, runErr := OpenRunFile(path)
+	if runErr != nil {
+		return nil, fmt.Errorf("neither a database nor a run file: %v", err)
+	}
+	if run.Len() > 0 {
+		var first [LEGACY_TM_SIZE]byte
+		if _, readErr := run.file.ReadAt(first[:], 0); readErr != nil || !isLegacyTM(first[:]) {
+			run.Close()
+			return nil, err
+		}
+	}
+	return run, nil
+}
+
+func (db *Database) readHeader() error {
+	info, err := db.file.Stat()
+	if err != nil {
+		return err
+	}
+	size := int(info.Size())
+
+	if !db.HasHeader {
+		if size%LEGACY_TM_SIZE != 0 {
+			return fmt.Errorf("not made of %d-byte machines", LEGACY_TM_SIZE)
+		}
+		db.Header = DatabaseHeader{NbMachines: size / LEGACY_TM_SIZE}
+		return nil
+	}
+
+	buffer := make([]byte, DB_HEADER_SIZE)
+	if _, err := db.file.ReadAt(buffer, 0); err != nil {
+		return err
+	}
+	if db.Header, err = DecodeDatabaseHeader(buffer); err != nil {
+		return err
+	}
+	if size != DB_HEADER_SIZE+db.Header.NbMachines*LEGACY_TM_SIZE {
+		return fmt.Errorf("the header announces %d machines but the file has %d bytes",
+			db.Header.NbMachines, size)
+	}
+	return nil
+}
+
+func (db *Database) Close() error {
+	return db.file.Close()
+}
+
+func (db *Database) Len() int {
+	return db.Header.NbMachines
+}
+
+// Checks that the machines of the database were enumerated with the given
+// parameters, which version 0 headers and run files do not record
+func (db *Database) CheckParameters(params DatabaseParameters) error {
+	if db.Header.Version == 0 {
+		return errors.New("the database does not record its enumeration parameters")
+	}
+
+	actual := db.Header.Parameters
+	var differences []string
+	differ := func(name string, actual interface{}, expected interface{}) {
+		if actual != expected {
+			differences = append(differences, fmt.Sprintf("%s %v instead of %v", name, actual, expected))
+		}
+	}
+	differ("number of states", actual.NbStates, params.NbStates)
+	differ("number of symbols", actual.NbSymbols, params.NbSymbols)
+	differ("time limit", actual.LimitTime, params.LimitTime)
+	differ("tape length", actual.LimitSpace, params.LimitSpace)
+	differ("boundary", actual.Boundary, params.Boundary)
+	differ("pruning", actual.ActivateFiltering, params.ActivateFiltering)
+	differ("cycle detection", actual.DetectCycles, params.DetectCycles)
+
+	if len(differences) > 0 {
+		return errors.New("the database was enumerated with " + strings.Join(differences, ", "))
+	}
+	return nil
+}
+
+func (db *Database) offset(i int) int64 {
+	if db.HasHeader {
+		return int64(DB_HEADER_SIZE + i*LEGACY_TM_SIZE)
+	}
+	return int64(i * LEGACY_TM_SIZE)
+}
+
+func (db *Database) Get(i int) (tm TM, err error) {
+	if i < 0 || i >= db.Len() {
+		return tm, errors.New("invalid db index")
+	}
+
+	var buffer [LEGACY_TM_SIZE]byte
+	if _, err := db.file.ReadAt(buffer[:], db.offset(i)); err != nil {
+		return tm, err
+	}
+	return DecodeLegacyTM(buffer[:])
+}
+
+// Part of the database a machine comes from, header-less run files have a
+// single SECTION_NONE section
+type DatabaseSection byte
+
+const (
+	SECTION_NONE DatabaseSection = iota
+	SECTION_UNDECIDED_TIME
+	SECTION_UNDECIDED_SPACE
+)
+
+func (s DatabaseSection) String() string {
+	names := [...]string{"none", "undecided_time", "undecided_space"}
+	if int(s) >= len(names) {
+		return "unknown"
+	}
+	return names[s]
+}
+
+func (db *Database) Section(i int) DatabaseSection {
+	if !db.HasHeader {
+		return SECTION_NONE
+	}
+	if i < db.Header.NbUndecidedTime {
+		return SECTION_UNDECIDED_TIME
+	}
+	return SECTION_UNDECIDED_SPACE
+}
+
+// Indices [start, end) of the machines of a section
+func (db *Database) SectionRange(section DatabaseSection) (start int, end int) {
+	switch {
+	case !db.HasHeader && section == SECTION_NONE:
+		return 0, db.Len()
+	case db.HasHeader && section == SECTION_UNDECIDED_TIME:
+		return 0, db.Header.NbUndecidedTime
+	case db.HasHeader && section == SECTION_UNDECIDED_SPACE:
+		return db.Header.NbUndecidedTime, db.Len()
+	}
+	return 0, 0
+}
+
+// Indices [start, end) of the k-th of n contiguous shards of about the same
+// size, for instance to split the database between parallel deciders
+func (db *Database) Shard(k int, n int) (start int, end int, err error) {
+	if n < 1 || k < 0 || k >= n {
+		return 0, 0, fmt.Errorf("invalid shard %d of %d", k, n)
+	}
+	return k * db.Len() / n, (k + 1) * db.Len() / n, nil
+}
+
+// Sequential reading of the machines of a range of the database:
+//
+//	start, end, err := db.Shard(k, n)
+//	...
+//	it := db.Iterate(start, end)
+//	for it.Next() {
+//		i, tm := it.Index(), it.TM()
+//		...
+//	}
+//	if it.Err() != nil { ... }
+type DatabaseIterator struct {
+	db     *Database
+	reader *bufio.Reader
+	index  int
+	end    int
+	tm     TM
+	err    error
+}
+
+func (db *Database) Iterate(start int, end int) *DatabaseIterator {
+	start = MaxI(0, start)
+	end = MinI(db.Len(), end)
+	length := int64(MaxI(0, end-start) * LEGACY_TM_SIZE)
+
+	return &DatabaseIterator{
+		db:     db,
+		reader: bufio.NewReaderSize(io.NewSectionReader(db.file, db.offset(start), length), 1<<16),
+		index:  start - 1,
+		end:    end,
+	}
+}
+
+func (it *DatabaseIterator) Next() bool {
+	if it.err != nil || it.index+1 >= it.end {
+		return false
+	}
+
+	var buffer [LEGACY_TM_SIZE]byte
+	if _, err := io.ReadFull(it.reader, buffer[:]); err != nil {
+		it.err = err
+		return false
+	}
+	if it.tm, it.err = DecodeLegacyTM(buffer[:]); it.err != nil {
+		return false
+	}
+	it.index += 1
+	return true
+}
+
+// Index in the database of the current machine
+func (it *DatabaseIterator) Index() int {
+	return it.index
+}
+
+func (it *DatabaseIterator) TM() TM {
+	return it.tm
+}
+
+func (it *DatabaseIterator) Section() DatabaseSection {
+	return it.db.Section(it.index)
+}
+
+func (it *DatabaseIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,305 @@
+// Here we test the assembly of the database
+package bbchallenge
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Writes the given machines to a run file and returns its path
+func writeRunFile(t *testing.T, machines [][]byte) string {
+	path := filepath.Join(t.TempDir(), "run_undecided")
+	if err := ioutil.WriteFile(path, bytes.Join(machines, nil), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMergeDatabase(t *testing.T) {
+	random := rand.New(rand.NewSource(0))
+	var machines [][]byte
+	for i := 0; i < 50; i += 1 {
+		machine := make([]byte, LEGACY_TM_SIZE)
+		random.Read(machine)
+		machines = append(machines, machine)
+	}
+
+	// The second run overlaps with the first one
+	timePaths := []string{writeRunFile(t, machines[0:20]), writeRunFile(t, machines[15:30])}
+	spacePaths := []string{writeRunFile(t, machines[30:50]), writeRunFile(t, machines[30:31])}
+
+	var db bytes.Buffer
+	report, err := MergeDatabase(&db, DatabaseHeader{}, timePaths, spacePaths)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := DatabaseHeader{NbUndecidedTime: 30, NbUndecidedSpace: 20, NbMachines: 50, Sorted: true}
+	if report.Header != expected || report.NbDuplicateUndecidedTime != 5 || report.NbDuplicateUndecidedSpace != 1 {
+		t.Error(report)
+	}
+	if !bytes.Equal(db.Bytes()[:12], []byte{0, 0, 0, 30, 0, 0, 0, 20, 0, 0, 0, 50}) || db.Bytes()[12] != 1 {
+		t.Error(db.Bytes()[:DB_HEADER_SIZE])
+	}
+
+	h, err := VerifyDatabase(bytes.NewReader(db.Bytes()))
+	if err != nil || h != expected {
+		t.Error(h, err)
+	}
+
+	// Every machine ends up in its section
+	content := db.Bytes()[DB_HEADER_SIZE:]
+	timeSection := content[:30*LEGACY_TM_SIZE]
+	for i, machine := range machines {
+		inTime := bytes.Contains(timeSection, machine)
+		if inTime != (i < 30) || !bytes.Contains(content, machine) {
+			t.Error(i, inTime)
+		}
+	}
+
+	// Unsorted or truncated databases are rejected
+	unsorted := append([]byte(nil), db.Bytes()...)
+	copy(unsorted[DB_HEADER_SIZE:], machines[0])
+	copy(unsorted[DB_HEADER_SIZE+LEGACY_TM_SIZE:], machines[0])
+	if _, err := VerifyDatabase(bytes.NewReader(unsorted)); err == nil {
+		t.Error("duplicate machines must be detected")
+	}
+	if _, err := VerifyDatabase(bytes.NewReader(db.Bytes()[:db.Len()-1])); err == nil {
+		t.Error("truncated database must be detected")
+	}
+
+	if _, err := MergeDatabase(&db, DatabaseHeader{}, []string{writeRunFile(t, [][]byte{machines[0][:10]})}, nil); err == nil {
+		t.Error("run files must be made of 30-byte machines")
+	}
+}
+
+func TestDatabase(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	var machines [][]byte
+	for i := 0; i < 40; i += 1 {
+		machine := make([]byte, LEGACY_TM_SIZE)
+		random.Read(machine)
+		machine[2] = LEGACY_H
+		machines = append(machines, machine)
+	}
+
+	params := getSmallRunParameters(5, 13, BOUNDARY_HALT)
+	path := filepath.Join(t.TempDir(), "db")
+	var content bytes.Buffer
+	if _, err := MergeDatabase(&content, params.DatabaseHeader(Metrics{}), []string{writeRunFile(t, machines[:15])}, []string{writeRunFile(t, machines[15:])}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, content.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if db.Len() != 40 || db.Header.NbUndecidedTime != 15 {
+		t.Error(db.Header)
+	}
+	if err := db.CheckParameters(params.DatabaseParameters()); err != nil {
+		t.Error(err)
+	}
+	params.LimitSpace = 12
+	if err := db.CheckParameters(params.DatabaseParameters()); err == nil {
+		t.Error("the tape length of the database is 13")
+	}
+	if start, end := db.SectionRange(SECTION_UNDECIDED_SPACE); start != 15 || end != 40 {
+		t.Error(start, end)
+	}
+	if _, err := db.Get(40); err == nil {
+		t.Error("index 40 is out of range")
+	}
+
+	// Shards cover the database, and the iterator agrees with Get
+	seen := 0
+	for k := 0; k < 3; k += 1 {
+		start, end, err := db.Shard(k, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it := db.Iterate(start, end)
+		for it.Next() {
+			tm, err := db.Get(it.Index())
+			if err != nil || tm != it.TM() || tm[2] != H {
+				t.Error(it.Index(), err)
+			}
+			if it.Section() != db.Section(it.Index()) || (it.Section() == SECTION_UNDECIDED_TIME) != (it.Index() < 15) {
+				t.Error(it.Index(), it.Section())
+			}
+			if it.Index() != seen {
+				t.Error(it.Index(), seen)
+			}
+			seen += 1
+		}
+		if it.Err() != nil {
+			t.Error(it.Err())
+		}
+	}
+	if seen != 40 {
+		t.Error(seen)
+	}
+	for _, shard := range [][2]int{{0, 0}, {-1, 3}, {3, 3}} {
+		if _, _, err := db.Shard(shard[0], shard[1]); err == nil {
+			t.Error("invalid shard", shard)
+		}
+	}
+	if SECTION_UNDECIDED_SPACE.String() != "undecided_space" || DatabaseSection(3).String() != "unknown" {
+		t.Error(DatabaseSection(3))
+	}
+
+	// Header-less run files
+	run, err := OpenRunFile(writeRunFile(t, machines))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer run.Close()
+	tm, err := run.Get(7)
+	if err != nil || run.Len() != 40 || run.Section(7) != SECTION_NONE || !bytes.Equal(tm[3:LEGACY_TM_SIZE], machines[7][3:]) {
+		t.Error(run.Len(), tm, err)
+	}
+
+	// A run file is not a database, and the other way around
+	if _, err := OpenDatabase(writeRunFile(t, machines[:3])); err == nil {
+		t.Error("run files have no header")
+	}
+	if _, err := OpenRunFile(writeRunFile(t, [][]byte{content.Bytes()[:DB_HEADER_SIZE], machines[0][:10]})); err == nil {
+		t.Error("run files are made of 30-byte machines")
+	}
+
+}
+
+// Files are only read as run files if they start with a machine, a database
+// whose header cannot be read is not
+func TestOpenDatabaseOrRunFile(t *testing.T) {
+	bb5, err := EncodeLegacyTM(5, 2, getBB5Winner())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := getSmallRunParameters(5, 13, BOUNDARY_STAY)
+	var content bytes.Buffer
+	if _, err := MergeDatabase(&content, params.DatabaseHeader(Metrics{}), []string{writeRunFile(t, [][]byte{bb5[:]})}, nil); err != nil {
+		t.Fatal(err)
+	}
+	database := content.Bytes()
+	unsupported := append([]byte{}, database...)
+	unsupported[13] = DB_HEADER_VERSION + 1
+	corrupt := append([]byte{}, database...)
+	corrupt[3] += 1
+	// Version 0 header announcing 2 + 0 machines out of 1
+	seed := append(make([]byte, DB_HEADER_SIZE), bb5[:]...)
+	seed[3], seed[11] = 2, 1
+
+	for i, c := range []struct {
+		content   []byte
+		hasHeader bool
+		ok        bool
+	}{
+		{database, true, true},
+		{append(bb5[:], bb5[:]...), false, true},
+		{nil, false, true},
+		{unsupported, false, false},
+		{corrupt, false, false},
+		{seed, false, false},
+		{bb5[:10], false, false},
+	} {
+		db, err := OpenDatabaseOrRunFile(writeRunFile(t, [][]byte{c.content}))
+		if (err == nil) != c.ok {
+			t.Error(i, err)
+			continue
+		}
+		if err == nil {
+			if db.HasHeader != c.hasHeader {
+				t.Error(i, db.HasHeader)
+			}
+			db.Close()
+		}
+	}
+}
+
+func TestDatabaseHeaderParameters(t *testing.T) {
+	params := getSmallRunParameters(5, 12289, BOUNDARY_WRAP)
+	params.LimitTime = 47176870
+	params.DetectCycles = true
+	header := params.DatabaseHeader(Metrics{NbUndecidedTime: 3, NbUndecidedSpace: 4})
+
+	encoded := header.Encode()
+	if len(encoded) != DB_HEADER_SIZE || encoded[13] != DB_HEADER_VERSION {
+		t.Fatal(encoded)
+	}
+	decoded, err := DecodeDatabaseHeader(encoded)
+	if err != nil || decoded != header || decoded.NbMachines != 7 {
+		t.Error(decoded, err)
+	}
+
+	// The header of the seed database
+	seed := []byte{0, 0xDA, 0x89, 0x6D, 0x04, 0x6E, 0x5E, 0x93, 0x05, 0x48, 0xE8, 0x00, 1}
+	seed = append(seed, make([]byte, DB_HEADER_SIZE-len(seed))...)
+	decoded, err = DecodeDatabaseHeader(seed)
+	expected := DatabaseHeader{NbUndecidedTime: 14322029, NbUndecidedSpace: 74342035, NbMachines: 88664064, Sorted: true}
+	if err != nil || decoded != expected {
+		t.Error(decoded, err)
+	}
+
+	encoded[13] = DB_HEADER_VERSION + 1
+	if _, err := DecodeDatabaseHeader(encoded); err == nil {
+		t.Error("unknown versions must be rejected")
+	}
+	encoded[13] = DB_HEADER_VERSION
+	encoded[14] = 6
+	if _, err := DecodeDatabaseHeader(encoded); err == nil {
+		t.Error("the database only holds <= 5-state machines")
+	}
+
+	// Runs with different parameters cannot be merged
+	other := params
+	other.MyTasks = []int{3}
+	merged, err := MergeHeaderParameters([]DatabaseHeader{header, other.DatabaseHeader(Metrics{})})
+	if err != nil || merged.Version != DB_HEADER_VERSION || merged.Parameters != header.Parameters {
+		t.Error(merged, err)
+	}
+	other.ActivateFiltering = !other.ActivateFiltering
+	if _, err := MergeHeaderParameters([]DatabaseHeader{header, other.DatabaseHeader(Metrics{})}); err == nil {
+		t.Error("pruning settings differ")
+	}
+	if _, err := MergeHeaderParameters([]DatabaseHeader{header, {}}); err == nil {
+		t.Error("version 0 runs have no parameters")
+	}
+}
+
+// Run headers describe the output files of runs in any format
+func TestRunHeader(t *testing.T) {
+	params := getSmallRunParameters(6, 12, BOUNDARY_HALT)
+	params.NbSymbols = 3
+	params.Format = FORMAT_JSONL
+	params.TaskDivisor = 4
+	params.MyTasks = []int{1, 3}
+	header := params.RunHeader(Metrics{NbHaltingMachines: 5, MaxNbSteps: 42, BBChampion: getBB2Winner()})
+
+	path := filepath.Join(t.TempDir(), "run_header.json")
+	if err := WriteRunHeader(path, header); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadRunHeader(path)
+	if err != nil || !reflect.DeepEqual(loaded, header) {
+		t.Error(loaded, err)
+	}
+
+	header.Version = RUN_HEADER_VERSION + 1
+	if err := WriteRunHeader(path, header); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRunHeader(path); err == nil {
+		t.Error("unknown versions must be rejected")
+	}
+}
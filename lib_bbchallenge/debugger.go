@@ -0,0 +1,356 @@
+// Here we step through the run of a single LBA, forwards and backwards, to
+// watch it when a decider disagrees with simulation
+package bbchallenge
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Configurations are saved every DEBUGGER_SNAPSHOT_PERIOD steps, rewinding
+// restarts from the last saved configuration before the target time
+const DEBUGGER_SNAPSHOT_PERIOD = 4096
+
+// Maximal number of steps of a single command, so that running a machine
+// which never halts gives the hand back
+const DEBUGGER_MAX_RUN = 100_000_000
+
+type transition struct {
+	state byte
+	read  byte
+}
+
+type Debugger struct {
+	tm        TM
+	nbStates  byte
+	nbSymbols byte
+	boundary  BoundaryMode
+
+	config configuration
+	Time   int // Number of steps done
+	// How the run ended, STEP_OK while it goes on (or after reaching H)
+	end stepResult
+
+	snapshots   []configuration
+	breakpoints map[transition]bool
+}
+
+func NewDebugger(tm TM, nbStates byte, nbSymbols byte, limitSpace int, boundary BoundaryMode) *Debugger {
+	d := &Debugger{
+		tm:          tm,
+		nbStates:    nbStates,
+		nbSymbols:   nbSymbols,
+		boundary:    boundary,
+		config:      newConfiguration(nbSymbols, limitSpace),
+		breakpoints: make(map[transition]bool),
+	}
+	d.snapshot()
+	return d
+}
+
+func (d *Debugger) snapshot() {
+	if d.Time%DEBUGGER_SNAPSHOT_PERIOD == 0 && len(d.snapshots) == d.Time/DEBUGGER_SNAPSHOT_PERIOD {
+		saved := newConfiguration(d.nbSymbols, len(d.config.tape))
+		saved.set(&d.config)
+		d.snapshots = append(d.snapshots, saved)
+	}
+}
+
+// Whether the machine halted or rejected
+func (d *Debugger) Stopped() bool {
+	return d.config.state == H || d.end != STEP_OK
+}
+
+// Returns false if the machine already stopped
+func (d *Debugger) Step() bool {
+	if d.Stopped() {
+		return false
+	}
+
+	result, _ := d.config.step(d.tm, d.boundary)
+	d.end = result
+	// The configuration does not change on an undefined transition. Final
+	// configurations are not saved, rewinding to them replays the last step
+	// so that the run ends again.
+	if result != STEP_UNDEFINED {
+		d.Time += 1
+	}
+	if result == STEP_OK {
+		d.snapshot()
+	}
+	return true
+}
+
+// Goes back to the configuration at the given time, which must be at most
+// the current time
+func (d *Debugger) Rewind(time int) {
+	if time < 0 || time > d.Time {
+		return
+	}
+
+	i := MinI(time/DEBUGGER_SNAPSHOT_PERIOD, len(d.snapshots)-1)
+	d.config.set(&d.snapshots[i])
+	d.Time = i * DEBUGGER_SNAPSHOT_PERIOD
+	d.end = STEP_OK
+	for d.Time < time {
+		d.Step()
+	}
+}
+
+// Steps until stop returns true, a breakpoint is reached or the machine
+// stops, for at most maxSteps steps. Returns why it stopped.
+func (d *Debugger) Run(maxSteps int, stop func(d *Debugger) bool) string {
+	for i := 0; i < maxSteps; i += 1 {
+		if !d.Step() {
+			return "the machine stopped"
+		}
+		if d.Stopped() {
+			return d.Status()
+		}
+		if stop != nil && stop(d) {
+			return "condition reached"
+		}
+		if d.breakpoints[d.nextTransition()] {
+			return fmt.Sprintf("breakpoint %s", d.transitionName(d.nextTransition()))
+		}
+	}
+	return fmt.Sprintf("still running after %d steps", maxSteps)
+}
+
+func (d *Debugger) nextTransition() transition {
+	return transition{d.config.state, d.config.tape[d.config.head]}
+}
+
+func (d *Debugger) transitionName(t transition) string {
+	return fmt.Sprintf("%c%d", stateLetter(t.state), t.read)
+}
+
+func (d *Debugger) parseState(s string) (byte, error) {
+	if len(s) != 1 || s[0] < 'A' || s[0] >= 'A'+d.nbStates {
+		return 0, fmt.Errorf("invalid state '%s'", s)
+	}
+	return s[0] - 'A' + 1, nil
+}
+
+// Parses transitions such as B1: state B reading 1
+func (d *Debugger) parseTransition(s string) (t transition, err error) {
+	if len(s) != 2 {
+		return t, fmt.Errorf("invalid transition '%s', e.g. B1 for state B reading 1", s)
+	}
+	if t.state, err = d.parseState(s[:1]); err != nil {
+		return t, err
+	}
+	t.read = s[1] - '0'
+	if t.read >= d.nbSymbols {
+		return t, fmt.Errorf("invalid symbol in '%s'", s)
+	}
+	return t, nil
+}
+
+func (d *Debugger) Status() string {
+	switch {
+	case d.end == STEP_UNDEFINED:
+		return fmt.Sprintf("halted on undefined transition %s (%d steps)",
+			d.transitionName(d.nextTransition()), d.Time+1)
+	case d.end == STEP_WALL_HALT:
+		return "halted on the edge of the tape"
+	case d.end == STEP_WALL_REJECT:
+		return "rejected on the edge of the tape"
+	case d.config.state == H:
+		return "halted"
+	}
+	return "running"
+}
+
+func (d *Debugger) String() string {
+	return fmt.Sprintf("%d %s", d.Time, TapeString(d.config.tape, d.config.head, d.config.state))
+}
+
+const debuggerHelp = `Commands:
+  s, step [N]        do N steps (default 1)
+  r, run             run until a breakpoint or the end of the run
+  u, until state X   run until the machine is in state X
+  u, until pos N     run until the head is on cell N (starting at 0)
+  u, until time T    run until time T
+  b, break X0        stop before each transition of state X reading 0
+  d, delete X0       remove a breakpoint
+  i, info            list the breakpoints
+  back [N]           go back N steps (default 1)
+  g, goto T          go to time T, forwards or backwards, ignoring breakpoints
+  p, print           print the configuration
+  h, help            print this help
+  q, quit
+Configurations are printed as: time tape, with the head shown as State[symbol].
+`
+
+func parseCount(args []string, byDefault int) (int, error) {
+	if len(args) == 0 {
+		return byDefault, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid number '%s'", args[0])
+	}
+	return n, nil
+}
+
+// Executes one command of the REPL, returns true on quit
+func (d *Debugger) Execute(command string, w io.Writer) (quit bool, err error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return false, nil
+	}
+	name, args := fields[0], fields[1:]
+
+	switch name {
+	case "s", "step":
+		n, err := parseCount(args, 1)
+		if err != nil {
+			return false, err
+		}
+		for i := 0; i < n; i += 1 {
+			if !d.Step() {
+				break
+			}
+		}
+		if d.Stopped() {
+			fmt.Fprintln(w, d.Status())
+		}
+
+	case "r", "run":
+		fmt.Fprintln(w, d.Run(DEBUGGER_MAX_RUN, nil))
+
+	case "u", "until":
+		if len(args) != 2 {
+			return false, errors.New("usage: until state X | pos N | time T")
+		}
+		stop, err := d.untilCondition(args[0], args[1])
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprintln(w, d.Run(DEBUGGER_MAX_RUN, stop))
+
+	case "b", "break", "d", "delete":
+		if len(args) == 0 {
+			return false, errors.New("give a transition, e.g. B1")
+		}
+		for _, arg := range args {
+			t, err := d.parseTransition(arg)
+			if err != nil {
+				return false, err
+			}
+			if name == "b" || name == "break" {
+				d.breakpoints[t] = true
+			} else {
+				delete(d.breakpoints, t)
+			}
+		}
+		return false, nil
+
+	case "i", "info":
+		var names []string
+		for t := range d.breakpoints {
+			names = append(names, d.transitionName(t))
+		}
+		sort.Strings(names)
+		fmt.Fprintln(w, "Breakpoints:", strings.Join(names, " "))
+		fmt.Fprintln(w, "Status:", d.Status())
+		return false, nil
+
+	case "back":
+		n, err := parseCount(args, 1)
+		if err != nil {
+			return false, err
+		}
+		d.Rewind(MaxI(0, d.Time-n))
+
+	case "g", "goto":
+		if len(args) != 1 {
+			return false, errors.New("usage: goto T")
+		}
+		time, err := parseCount(args, 0)
+		if err != nil {
+			return false, err
+		}
+		if time <= d.Time {
+			d.Rewind(time)
+		} else if time-d.Time > DEBUGGER_MAX_RUN {
+			return false, fmt.Errorf("cannot go more than %d steps forward at once", DEBUGGER_MAX_RUN)
+		}
+		// Breakpoints are ignored
+		for d.Time < time {
+			if !d.Step() {
+				fmt.Fprintln(w, d.Status())
+				break
+			}
+		}
+
+	case "p", "print":
+
+	case "h", "help":
+		fmt.Fprint(w, debuggerHelp)
+		return false, nil
+
+	case "q", "quit":
+		return true, nil
+
+	default:
+		return false, fmt.Errorf("unknown command '%s', type 'help' for the list of commands", name)
+	}
+
+	fmt.Fprintln(w, d)
+	return false, nil
+}
+
+func (d *Debugger) untilCondition(kind string, value string) (func(d *Debugger) bool, error) {
+	switch kind {
+	case "state":
+		state, err := d.parseState(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(d *Debugger) bool { return d.config.state == state }, nil
+	case "pos":
+		pos, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid position '%s'", value)
+		}
+		return func(d *Debugger) bool { return d.config.head == pos }, nil
+	case "time":
+		time, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time '%s'", value)
+		}
+		if time <= d.Time {
+			return nil, errors.New("time is in the past, use back or goto")
+		}
+		return func(d *Debugger) bool { return d.Time >= time }, nil
+	}
+	return nil, fmt.Errorf("unknown condition '%s', must be state, pos or time", kind)
+}
+
+// Reads commands from r until quit or the end of the input
+func (d *Debugger) REPL(r io.Reader, w io.Writer, prompt string) error {
+	fmt.Fprintln(w, d)
+
+	scanner := bufio.NewScanner(r)
+	for {
+		fmt.Fprint(w, prompt)
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+
+		quit, err := d.Execute(scanner.Text(), w)
+		if err != nil {
+			fmt.Fprintln(w, err)
+		}
+		if quit {
+			return nil
+		}
+	}
+}
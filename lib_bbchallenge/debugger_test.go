@@ -0,0 +1,99 @@
+// Here we test the step-through debugger
+package bbchallenge
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDebuggerAgreesWithSimulate(t *testing.T) {
+	for _, c := range getSimulationCases() {
+		if c.haltStatus != HALT {
+			continue
+		}
+		d := NewDebugger(c.tm, 5, 2, c.limitSpace, c.boundary)
+		d.Run(c.limitTime, nil)
+
+		steps := d.Time
+		if d.end == STEP_UNDEFINED {
+			steps += 1
+		}
+		if !d.Stopped() || steps != c.stepsCount {
+			t.Error(c.name, d.Status(), steps, c.stepsCount)
+		}
+	}
+}
+
+func TestDebuggerRewind(t *testing.T) {
+	// All transitions are defined and there are no walls: runs forever
+	tm, _, _, _ := ParseTM("1RB1LB_1LA0RC_0LC1RA", 'Z')
+	d := NewDebugger(tm, 3, 2, 40, BOUNDARY_WRAP)
+	d.Run(3*DEBUGGER_SNAPSHOT_PERIOD+17, nil)
+
+	// Configurations of a second debugger stepping forward only
+	forward := NewDebugger(tm, 3, 2, 40, BOUNDARY_WRAP)
+	for _, time := range []int{2*DEBUGGER_SNAPSHOT_PERIOD + 5, DEBUGGER_SNAPSHOT_PERIOD, 3, 0} {
+		d.Rewind(time)
+		forward.Run(time-forward.Time, nil)
+		if forward.Time > time {
+			forward = NewDebugger(tm, 3, 2, 40, BOUNDARY_WRAP)
+			forward.Run(time, nil)
+		}
+		if d.Time != time || d.String() != forward.String() {
+			t.Error(time, d.Time, forward.Time)
+		}
+	}
+
+	// Rewinding a stopped machine and running it again stops it again
+	d = NewDebugger(getBB2Winner(), 2, 2, 5, BOUNDARY_STAY)
+	d.Run(100, nil)
+	d.Rewind(2)
+	if d.Stopped() || d.Run(100, nil) != "halted" || d.Time != 4 {
+		t.Error(d.Status(), d.Time)
+	}
+}
+
+func TestDebuggerREPL(t *testing.T) {
+	script := `step
+break A1
+run
+info
+until pos 0
+back 2
+goto 4
+goto 100000005
+until state Q
+quit
+step
+`
+	var out bytes.Buffer
+	d := NewDebugger(getBB2Winner(), 2, 2, 5, BOUNDARY_STAY)
+	if err := d.REPL(strings.NewReader(script), &out, "> "); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"0 A[0] 0  0  0  0 ",
+		"1  1 B[0] 0  0  0 ",
+		"breakpoint A1",
+		"2 A[1] 1  0  0  0 ",
+		"Breakpoints: A1",
+		"3 B[1] 1  0  0  0 ",
+		"1  1 B[0] 0  0  0 ",
+		"4  1 Z[1] 0  0  0 ",
+		"cannot go more than 100000000 steps forward at once",
+		"invalid state 'Q'",
+	}
+	text := out.String()
+	for _, line := range expected {
+		i := strings.Index(text, line)
+		if i < 0 {
+			t.Fatal(line, "\n", out.String())
+		}
+		text = text[i+len(line):]
+	}
+	if d.Time != 4 {
+		t.Error("commands after quit must be ignored")
+	}
+}
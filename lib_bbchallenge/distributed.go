@@ -0,0 +1,555 @@
+// Here we distribute the tasks of an enumeration (see partition.go) between
+// workers running on other computers
+package bbchallenge
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The coordinator and its workers talk JSON over HTTP:
+//   - POST /lease: a worker asks for a task. Answers a Lease, 204 (No Content)
+//     when all remaining tasks are leased or 410 (Gone) once all are done
+//   - POST /heartbeat: a worker keeps its lease alive while it enumerates
+//   - PUT /results?lease=<id>&kind=<kind>: a worker uploads one of the
+//     record files of its task, in the format of the run
+//   - POST /done: a worker sends the metrics of its task, once all its
+//     record files are uploaded
+//   - GET /status: progress of the run
+//
+// A lease expires when it is not renewed for LeaseDuration, its task is then
+// handed out again and anything later sent with the expired lease is refused
+// with 409 (Conflict).
+
+// Kinds of record files uploaded by workers
+var ResultKinds = []string{"halting", "undecided_time", "undecided_space"}
+
+type LeaseRequest struct {
+	Worker string `json:"worker"`
+}
+
+type Lease struct {
+	ID       int64         `json:"lease"`
+	Task     int           `json:"task"`
+	Params   RunParameters `json:"params"`
+	Duration time.Duration `json:"duration"`
+}
+
+type Heartbeat struct {
+	Lease int64 `json:"lease"`
+}
+
+type TaskResult struct {
+	Lease   int64   `json:"lease"`
+	Metrics Metrics `json:"metrics"`
+}
+
+type CoordinatorStatus struct {
+	NbTasks   int     `json:"nb_tasks"`
+	NbPending int     `json:"nb_pending"`
+	NbLeased  int     `json:"nb_leased"`
+	NbDone    int     `json:"nb_done"`
+	Metrics   Metrics `json:"metrics"`
+}
+
+type taskStatus byte
+
+const (
+	TASK_PENDING taskStatus = iota
+	TASK_LEASED
+	TASK_DONE
+)
+
+type coordinatorTask struct {
+	status   taskStatus
+	lease    int64
+	worker   string
+	deadline time.Time
+}
+
+// Hands out the tasks of an enumeration and collects their results. The
+// records of each task are kept in Dir until WriteResults.
+type Coordinator struct {
+	Params        RunParameters
+	LeaseDuration time.Duration
+	Dir           string
+
+	Log io.Writer // Logging leases and results
+
+	mutex     sync.Mutex
+	tasks     []coordinatorTask
+	leases    map[int64]int // Task of each active lease
+	lastLease int64
+	nbDone    int
+	metrics   Metrics
+	done      chan bool
+
+	mux *http.ServeMux
+}
+
+func NewCoordinator(params RunParameters, dir string) *Coordinator {
+	params.TaskDivisor = MaxI(params.TaskDivisor, 1)
+	params.MyTasks = nil
+
+	c := &Coordinator{
+		Params:        params,
+		LeaseDuration: 5 * time.Minute,
+		Dir:           dir,
+		Log:           ioutil.Discard,
+		tasks:         make([]coordinatorTask, params.TaskDivisor),
+		leases:        make(map[int64]int),
+		done:          make(chan bool),
+		mux:           http.NewServeMux(),
+	}
+
+	c.mux.HandleFunc("/lease", c.handleLease)
+	c.mux.HandleFunc("/heartbeat", c.handleHeartbeat)
+	c.mux.HandleFunc("/results", c.handleResults)
+	c.mux.HandleFunc("/done", c.handleDone)
+	c.mux.HandleFunc("/status", c.handleStatus)
+	return c
+}
+
+func (c *Coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mux.ServeHTTP(w, r)
+}
+
+// Closed once all tasks are done
+func (c *Coordinator) Done() <-chan bool {
+	return c.done
+}
+
+func (c *Coordinator) Status() CoordinatorStatus {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	status := CoordinatorStatus{NbTasks: len(c.tasks), Metrics: c.metrics}
+	for _, task := range c.tasks {
+		switch task.status {
+		case TASK_PENDING:
+			status.NbPending += 1
+		case TASK_LEASED:
+			status.NbLeased += 1
+		case TASK_DONE:
+			status.NbDone += 1
+		}
+	}
+	return status
+}
+
+func (c *Coordinator) logf(format string, args ...interface{}) {
+	fmt.Fprintf(c.Log, time.Now().Format(time.RFC1123)+" "+format+"\n", args...)
+}
+
+// Hands expired leases back, the mutex must be held
+func (c *Coordinator) expireLeases() {
+	for id := range c.tasks {
+		task := &c.tasks[id]
+		if task.status == TASK_LEASED && time.Now().After(task.deadline) {
+			c.logf("lease %d of task %d by %s expired", task.lease, id, task.worker)
+			delete(c.leases, task.lease)
+			task.status = TASK_PENDING
+		}
+	}
+}
+
+// Task of an active lease, the mutex must be held
+func (c *Coordinator) leasedTask(lease int64) (int, bool) {
+	c.expireLeases()
+	id, ok := c.leases[lease]
+	return id, ok
+}
+
+func (c *Coordinator) resultPath(name string, kind string) string {
+	return filepath.Join(c.Dir, name+"_"+kind)
+}
+
+func (c *Coordinator) handleLease(w http.ResponseWriter, r *http.Request) {
+	var request LeaseRequest
+	if !decodeRequest(w, r, http.MethodPost, &request) {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.nbDone == len(c.tasks) {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+
+	c.expireLeases()
+	for id := range c.tasks {
+		task := &c.tasks[id]
+		if task.status != TASK_PENDING {
+			continue
+		}
+
+		c.lastLease += 1
+		*task = coordinatorTask{
+			status:   TASK_LEASED,
+			lease:    c.lastLease,
+			worker:   request.Worker,
+			deadline: time.Now().Add(c.LeaseDuration),
+		}
+		c.leases[task.lease] = id
+		c.logf("task %d leased to %s (lease %d)", id, request.Worker, task.lease)
+
+		writeResponse(w, Lease{ID: task.lease, Task: id, Params: c.Params, Duration: c.LeaseDuration})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Coordinator) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var heartbeat Heartbeat
+	if !decodeRequest(w, r, http.MethodPost, &heartbeat) {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	id, ok := c.leasedTask(heartbeat.Lease)
+	if !ok {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	c.tasks[id].deadline = time.Now().Add(c.LeaseDuration)
+}
+
+func (c *Coordinator) handleResults(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	lease, err := strconv.ParseInt(r.URL.Query().Get("lease"), 10, 64)
+	kind := r.URL.Query().Get("kind")
+	if err != nil || !isResultKind(kind) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	c.mutex.Lock()
+	_, ok := c.leasedTask(lease)
+	c.mutex.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	// Uploads are written under the name of the lease and only become the
+	// results of the task once it is done, an expired lease cannot overwrite
+	// the results of the next one
+	file, err := os.Create(c.resultPath("lease_"+strconv.FormatInt(lease, 10), kind))
+	if err == nil {
+		_, err = io.Copy(file, r.Body)
+		if errClose := file.Close(); err == nil {
+			err = errClose
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (c *Coordinator) handleDone(w http.ResponseWriter, r *http.Request) {
+	var result TaskResult
+	if !decodeRequest(w, r, http.MethodPost, &result) {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	id, ok := c.leasedTask(result.Lease)
+	if !ok {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	leaseName := "lease_" + strconv.FormatInt(result.Lease, 10)
+	for _, kind := range ResultKinds {
+		if _, err := os.Stat(c.resultPath(leaseName, kind)); err != nil {
+			http.Error(w, "missing "+kind+" results", http.StatusBadRequest)
+			return
+		}
+	}
+	for _, kind := range ResultKinds {
+		if err := os.Rename(c.resultPath(leaseName, kind), c.resultPath("task_"+strconv.Itoa(id), kind)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	delete(c.leases, result.Lease)
+	c.tasks[id].status = TASK_DONE
+	c.metrics.add(result.Metrics)
+	c.nbDone += 1
+	c.logf("task %d done by %s (%d/%d)", id, c.tasks[id].worker, c.nbDone, len(c.tasks))
+
+	if c.nbDone == len(c.tasks) {
+		close(c.done)
+	}
+}
+
+func (c *Coordinator) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, c.Status())
+}
+
+// Writes the records of the given kind of all tasks, in the order of the
+// tasks. All tasks must be done.
+func (c *Coordinator) WriteResults(kind string, w io.Writer) error {
+	if c.Status().NbDone != len(c.tasks) {
+		return errors.New("all tasks must be done before writing their results")
+	}
+
+	// Workers do not write headers, there must be only one
+	if c.Params.Format == FORMAT_CSV {
+		if _, err := io.WriteString(w, csvHeader); err != nil {
+			return err
+		}
+	}
+
+	for id := range c.tasks {
+		file, err := os.Open(c.resultPath("task_"+strconv.Itoa(id), kind))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Metrics of the done tasks
+func (c *Coordinator) Metrics() Metrics {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.metrics
+}
+
+func isResultKind(kind string) bool {
+	for _, resultKind := range ResultKinds {
+		if kind == resultKind {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request, method string, v interface{}) bool {
+	if r.Method != method {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeResponse(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// Runs the tasks handed out by a coordinator until all are done
+type DistributedWorker struct {
+	CoordinatorURL string
+	Name           string
+	NbWorkers      int           // Go routines of the enumeration of each task
+	PollInterval   time.Duration // Wait between two lease requests when no task is available
+	MaxFailures    int           // Consecutive failed requests to the coordinator before giving up
+	TempDir        string        // Where the records of a task are kept until uploaded
+
+	Client *http.Client
+	Log    io.Writer
+}
+
+func NewDistributedWorker(coordinatorURL string, name string) *DistributedWorker {
+	return &DistributedWorker{
+		CoordinatorURL: coordinatorURL,
+		Name:           name,
+		NbWorkers:      1,
+		PollInterval:   5 * time.Second,
+		MaxFailures:    10,
+		TempDir:        os.TempDir(),
+		Client:         http.DefaultClient,
+		Log:            ioutil.Discard,
+	}
+}
+
+var errLeaseLost = errors.New("lease lost")
+
+func (w *DistributedWorker) logf(format string, args ...interface{}) {
+	fmt.Fprintf(w.Log, time.Now().Format(time.RFC1123)+" "+format+"\n", args...)
+}
+
+// Returns the number of tasks done by this worker once the coordinator has
+// no more tasks, or an error if the coordinator cannot be reached
+func (w *DistributedWorker) Run() (nbTasks int, err error) {
+	failures := 0
+
+	for {
+		var lease Lease
+		status, err := w.post("/lease", LeaseRequest{Worker: w.Name}, &lease)
+
+		switch {
+		case err != nil:
+			failures += 1
+			w.logf("cannot reach coordinator: %s", err)
+			if failures >= w.MaxFailures {
+				return nbTasks, err
+			}
+			time.Sleep(w.PollInterval)
+			continue
+		case status == http.StatusGone:
+			return nbTasks, nil
+		case status == http.StatusNoContent:
+			time.Sleep(w.PollInterval)
+			continue
+		case status != http.StatusOK:
+			return nbTasks, fmt.Errorf("unexpected lease answer %d", status)
+		}
+		failures = 0
+
+		w.logf("running task %d (lease %d)", lease.Task, lease.ID)
+		err = w.runTask(lease)
+		if err == errLeaseLost {
+			w.logf("lease %d of task %d was lost", lease.ID, lease.Task)
+			continue
+		}
+		if err != nil {
+			return nbTasks, err
+		}
+		w.logf("task %d done", lease.Task)
+		nbTasks += 1
+	}
+}
+
+func (w *DistributedWorker) runTask(lease Lease) error {
+	params := lease.Params
+	params.MyTasks = []int{lease.Task}
+
+	files := make(map[string]*os.File)
+	for _, kind := range ResultKinds {
+		file, err := ioutil.TempFile(w.TempDir, "bbchallenge_task_"+strconv.Itoa(lease.Task)+"_"+kind)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(file.Name())
+		defer file.Close()
+		files[kind] = file
+	}
+
+	e := NewEnumerator(params)
+	e.NbWorkers = w.NbWorkers
+	e.HaltingSink = NewResultSink(params.Format, files["halting"], false)
+	e.UndecidedTimeSink = NewResultSink(params.Format, files["undecided_time"], false)
+	e.UndecidedSpaceSink = NewResultSink(params.Format, files["undecided_space"], false)
+
+	// Heartbeats until the enumeration is over
+	stop := make(chan bool)
+	lost := make(chan bool, 1)
+	go func() {
+		ticker := time.NewTicker(lease.Duration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				status, err := w.post("/heartbeat", Heartbeat{Lease: lease.ID}, nil)
+				if err == nil && status == http.StatusConflict {
+					lost <- true
+					return
+				}
+			}
+		}
+	}()
+
+	report := e.Enumerate()
+	close(stop)
+
+	select {
+	case <-lost:
+		return errLeaseLost
+	default:
+	}
+
+	for _, kind := range ResultKinds {
+		file := files[kind]
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		request, err := http.NewRequest(http.MethodPut,
+			w.CoordinatorURL+"/results?lease="+strconv.FormatInt(lease.ID, 10)+"&kind="+kind, file)
+		if err != nil {
+			return err
+		}
+		response, err := w.Client.Do(request)
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+
+		if response.StatusCode == http.StatusConflict {
+			return errLeaseLost
+		}
+		if response.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected upload answer %d", response.StatusCode)
+		}
+	}
+
+	status, err := w.post("/done", TaskResult{Lease: lease.ID, Metrics: report.Metrics}, nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusConflict {
+		return errLeaseLost
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("unexpected done answer %d", status)
+	}
+	return nil
+}
+
+// Posts `request` as JSON and decodes the answer in `response` if it is not
+// nil and the status is 200
+func (w *DistributedWorker) post(path string, request interface{}, response interface{}) (int, error) {
+	asJson, err := json.Marshal(request)
+	if err != nil {
+		return 0, err
+	}
+
+	answer, err := w.Client.Post(w.CoordinatorURL+path, "application/json", bytes.NewReader(asJson))
+	if err != nil {
+		return 0, err
+	}
+	defer answer.Body.Close()
+
+	if answer.StatusCode == http.StatusOK && response != nil {
+		if err := json.NewDecoder(answer.Body).Decode(response); err != nil {
+			return 0, err
+		}
+	}
+	return answer.StatusCode, nil
+}
@@ -0,0 +1,110 @@
+// Here we test the distribution of an enumeration between workers, on localhost
+package bbchallenge
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func getDistributedRunParameters() RunParameters {
+	params := getSmallRunParameters(3, 4, BOUNDARY_STAY)
+	params.TaskDivisor = 6
+	params.TaskDepth = 3
+	return params
+}
+
+func newTestWorker(url string, name string) *DistributedWorker {
+	w := NewDistributedWorker(url, name)
+	w.PollInterval = 10 * time.Millisecond
+	w.MaxFailures = 3
+	return w
+}
+
+// Runs the workers until the coordinator has no more tasks and checks that
+// the run adds up to the whole enumeration
+func checkDistributedRun(t *testing.T, c *Coordinator, workers []*DistributedWorker) {
+	whole := NewEnumerator(getSmallRunParameters(3, 4, BOUNDARY_STAY)).Enumerate()
+
+	nbTasks := make([]int, len(workers))
+	var wg sync.WaitGroup
+	for i, w := range workers {
+		wg.Add(1)
+		go func(i int, w *DistributedWorker) {
+			var err error
+			nbTasks[i], err = w.Run()
+			if err != nil {
+				t.Error(w.Name, err)
+			}
+			wg.Done()
+		}(i, w)
+	}
+	wg.Wait()
+
+	select {
+	case <-c.Done():
+	default:
+		t.Fatal("all workers returned before the end of the run")
+	}
+
+	total := 0
+	for _, n := range nbTasks {
+		total += n
+	}
+	if total != c.Params.TaskDivisor {
+		t.Error(nbTasks)
+	}
+
+	if c.Metrics() != whole.Metrics {
+		t.Error(c.Metrics(), whole.Metrics)
+	}
+
+	var halting bytes.Buffer
+	if err := c.WriteResults("halting", &halting); err != nil {
+		t.Fatal(err)
+	}
+	if halting.Len() != LEGACY_TM_SIZE*whole.NbHaltingMachines {
+		t.Error(halting.Len(), whole.NbHaltingMachines)
+	}
+}
+
+func TestDistributedRun(t *testing.T) {
+	c := NewCoordinator(getDistributedRunParameters(), t.TempDir())
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	checkDistributedRun(t, c, []*DistributedWorker{
+		newTestWorker(server.URL, "w1"),
+		newTestWorker(server.URL, "w2"),
+		newTestWorker(server.URL, "w3"),
+	})
+}
+
+// The task of a worker which disappears is handed out again once its lease
+// expires, and its late results are refused
+func TestDistributedLeaseExpiry(t *testing.T) {
+	c := NewCoordinator(getDistributedRunParameters(), t.TempDir())
+	c.LeaseDuration = 200 * time.Millisecond
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	ghost := newTestWorker(server.URL, "ghost")
+	var lease Lease
+	if status, err := ghost.post("/lease", LeaseRequest{Worker: ghost.Name}, &lease); err != nil || status != http.StatusOK {
+		t.Fatal(status, err)
+	}
+
+	checkDistributedRun(t, c, []*DistributedWorker{newTestWorker(server.URL, "w1")})
+
+	status, err := ghost.post("/done", TaskResult{Lease: lease.ID}, nil)
+	if err != nil || status != http.StatusConflict {
+		t.Error(status, err)
+	}
+	status, err = ghost.post("/lease", LeaseRequest{Worker: ghost.Name}, nil)
+	if err != nil || status != http.StatusGone {
+		t.Error(status, err)
+	}
+}
@@ -2,8 +2,11 @@
 package bbchallenge
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 	"runtime"
 	"sync"
 	"time"
@@ -16,92 +19,381 @@ const (
 	SIMULATION_C
 )
 
-// Package parameters
+// Parameters defining which machines an enumeration visits and how they are
+// simulated. They are saved in checkpoints so that resuming a run does not
+// need them to be given again.
+type RunParameters struct {
+	NbStates          byte
+	NbSymbols         byte
+	LimitTime         int
+	LimitSpace        int
+	Boundary          BoundaryMode
+	ActivateFiltering bool
+	DetectCycles      bool // Decide non-halting as soon as a configuration repeats (Go backend only)
+	Backend           SimulationBackend
+	TaskDivisor       int   // Number of tasks the enumeration is split in, see partition.go
+	TaskDepth         int   // Depth (number of defined transitions) of the roots of the tasks
+	MyTasks           []int // Which tasks to I do, all of them if empty
+	CheckpointDepth   int
+
+	// Format of the records written to the sinks. The enumeration does not
+	// use it, but the records of one run may be written by several processes:
+	// the coordinator hands it to the workers in their leases so that they
+	// upload records it can concatenate, checkpoints keep it so that a resumed
+	// run appends records in the same format and run headers tell readers of
+	// the files how to decode them.
+	Format RecordFormat
+}
 
-var TimeStart time.Time = time.Now()
+// Metrics of an enumeration or of a part of it
+type Metrics struct {
+	NbMachineSeen        int
+	NbMachinePruned      int
+	NbHaltingMachines    int
+	NbNonHaltingMachines int
+	NbUndecidedTime      int
+	NbUndecidedSpace     int
+	MaxNbSteps           int
+	MaxSpace             int
+	BBChampion           TM // Halting machine running for MaxNbSteps steps
+}
 
-var HaltingLog io.Writer        // Logging HALT machines
-var UndecidedTimeLog io.Writer  // Logging UNDECIDED_TIME machines
-var UndecidedSpaceLog io.Writer // Logging UNDECIDED_SPACE machines
-var BBRecordLog io.Writer       // Logging BB and BB_space record holders
+func (m *Metrics) add(other Metrics) {
+	m.NbMachineSeen += other.NbMachineSeen
+	m.NbMachinePruned += other.NbMachinePruned
+	m.NbHaltingMachines += other.NbHaltingMachines
+	m.NbNonHaltingMachines += other.NbNonHaltingMachines
+	m.NbUndecidedTime += other.NbUndecidedTime
+	m.NbUndecidedSpace += other.NbUndecidedSpace
+	m.addChampion(other.BBChampion, other.MaxNbSteps)
+	m.MaxSpace = MaxI(m.MaxSpace, other.MaxSpace)
+}
 
-var Verbose bool
-var LogFreq int64 = 30000000000 // 30 sec in ns
+// Among machines running for the same number of steps, the lexicographically
+// smallest is kept so that the champion does not depend on the order in which
+// go routines finish
+func isBetterChampion(tm TM, steps_count int, champion TM, champion_steps_count int) bool {
+	return steps_count > champion_steps_count ||
+		(steps_count == champion_steps_count && bytes.Compare(tm[:], champion[:]) < 0)
+}
 
-var ListAll bool // Whether to list all simulated machines
+func (m *Metrics) addChampion(tm TM, steps_count int) {
+	if isBetterChampion(tm, steps_count, m.BBChampion, m.MaxNbSteps) {
+		m.BBChampion = tm
+		m.MaxNbSteps = steps_count
+	}
+}
 
-var ActivateFiltering bool = true
+// Counts a single (non pruned) machine
+func (m *Metrics) addMachine(haltStatus HaltStatus, tm TM, steps_count int, space_count int) {
+	m.NbMachineSeen += 1
+
+	switch haltStatus {
+	case HALT:
+		m.NbHaltingMachines += 1
+		m.addChampion(tm, steps_count)
+		m.MaxSpace = MaxI(m.MaxSpace, space_count)
+	case NO_HALT:
+		m.NbNonHaltingMachines += 1
+	case UNDECIDED_TIME:
+		m.NbUndecidedTime += 1
+	case UNDECIDED_SPACE:
+		m.NbUndecidedSpace += 1
+	}
+}
 
-var SimulationLimitTime int = BB5
-var SimulationLimitSpace int = BB5_SPACE
+// Outcome of an enumeration
+type Report struct {
+	Metrics
+	RunTime       time.Duration
+	NbWorkers     int
+	NbStolenTasks int // Tasks run by another worker than the one which created them
+}
 
-var SlowDownInit int = 2 // How many recursion will be done on the stack before calling go routines
+// An enumeration of TMs: its configuration, where its results go and its
+// metrics. Several enumerators can run in the same process.
+type Enumerator struct {
+	RunParameters
 
-// At the root of the TM tree are always 12 machines (independently of nbStates)
-// Only 8 of them are interesting. We authorize the user to cut the computation
-// of the entire tree in 1, 2, 4, or 8 pieces. Useful when you have several computers!
-var TaskDivisor int = 1   // Can be either 1, 2, 4 or 8
-var TaskDivisorMe int = 0 // Wich task to I do
+	BBtUpperBound int
+	NbWorkers     int // Number of go routines enumerating the tree
 
-// Package outputs
+	Verbose bool
+	LogFreq int64 // ns between each stdout log in verbose mode
+	ListAll bool  // Whether to list all simulated machines
 
-var mutexMetrics sync.Mutex
-var NbMachineSeen int
-var NbMachinePruned int
-var NbHaltingMachines int
-var NbNonHaltingMachines int
-var NbUndecidedTime int
-var NbUndecidedSpace int
-var MaxNbSteps int
-var MaxSpace int
-var MaxNbGoRoutines int
+	HaltingSink        ResultSink // Records of HALT machines
+	UndecidedTimeSink  ResultSink // Records of UNDECIDED_TIME machines
+	UndecidedSpaceSink ResultSink // Records of UNDECIDED_SPACE machines
+	BBRecordLog        io.Writer  // Logging BB and BB_space record holders
+	ErrorLog           io.Writer  // Errors which do not stop the enumeration, e.g. failed checkpoints
 
-// Logging related internal variables
-var lastLogTime time.Time = time.Now()
-var notFirstLog bool
+	CheckpointPath string // Where to write checkpoints, checkpointing is disabled if empty
+	CheckpointFreq int64  // ns between each checkpoint
 
-// Invariant: tm's transition (state, read) is not defined
+	metrics   Metrics // Metrics restored from a checkpoint, the workers' ones are added to them
+	scheduler *scheduler
+	timeStart time.Time
+
+	// Guards the BB record holders and BBRecordLog
+	mutexRecords sync.Mutex
+	recordSteps  int
+	recordSpace  int
+
+	checkpoint *checkpointState
+
+	sweep *sweep // Set when the enumerator walks the trees of a sweep
+}
+
+// Returns an enumerator with default settings for the given parameters,
+// all its outputs but errors are discarded until set
+func NewEnumerator(params RunParameters) *Enumerator {
+	return &Enumerator{
+		RunParameters:      params,
+		BBtUpperBound:      UpperBound(params.NbStates, params.NbSymbols, params.LimitSpace),
+		NbWorkers:          runtime.GOMAXPROCS(0),
+		LogFreq:            30000000000, // 30 sec in ns
+		HaltingSink:        DiscardSink{},
+		UndecidedTimeSink:  DiscardSink{},
+		UndecidedSpaceSink: DiscardSink{},
+		BBRecordLog:        ioutil.Discard,
+		ErrorLog:           os.Stderr,
+		CheckpointFreq:     60000000000, // 60 sec in ns
+		checkpoint:         newCheckpointState(),
+	}
+}
+
+// Enumerates the subtree of tm as the former package-level Enumerate did:
+// with its default limits (BB5 steps, BB5_SPACE cells), pruning and the
+// default settings of an Enumerator. The metrics are returned instead of
+// being left in package variables, and slow_down is ignored as go routines
+// are now a pool of workers.
+//
+// Deprecated: use NewEnumerator and (*Enumerator).EnumerateFrom.
 func Enumerate(nbStates byte, tm TM, state byte, read byte,
 	previous_steps_count int, previous_space_count int,
-	slow_down int, simulation_backend SimulationBackend) {
+	slow_down int, simulation_backend SimulationBackend, boundary BoundaryMode) Report {
+
+	e := NewEnumerator(RunParameters{
+		NbStates:          nbStates,
+		NbSymbols:         2,
+		LimitTime:         BB5,
+		LimitSpace:        BB5_SPACE,
+		Boundary:          boundary,
+		ActivateFiltering: true,
+		Backend:           simulation_backend,
+		TaskDivisor:       1,
+	})
+	return e.EnumerateFrom(tm, state, read, previous_steps_count, previous_space_count)
+}
 
-	// Get the list of candidate target states
-	// taking all states up to the first completely undefined
-	// As in http://turbotm.de/~heiner/BB/mabu90.html#Enumeration
-	var target_states [MAX_STATES]byte
-	var definedTransitionCount byte
-	var undefinedTransitionCount byte
+// Enumerates all machines from the root of the TM tree
+func (e *Enumerator) Enumerate() Report {
+	// Making the initial transition 1RB actually loses quite a bit of generality in this case
+	return e.EnumerateFrom(TM{}, 1, 0, 0, 0)
+}
 
-	for iState := 0; iState < int(nbStates); iState += 1 {
-		target_states[iState] = byte(iState + 1)
+// Enumerates the subtree of tm
+// Invariant: tm's transition (state, read) is not defined
+func (e *Enumerator) EnumerateFrom(tm TM, state byte, read byte,
+	previous_steps_count int, previous_space_count int) Report {
+
+	e.timeStart = time.Now()
+	e.scheduler = newScheduler(MaxI(e.NbWorkers, 1))
+	root := task{
+		tm:          tm,
+		state:       state,
+		read:        read,
+		steps_count: previous_steps_count,
+		space_count: previous_space_count,
+	}
+	if e.sweep != nil {
+		root.lengths = 1<<uint(len(e.sweep.enumerators)) - 1
+	}
+	e.scheduler.push(e.scheduler.workers[0], root)
 
-		if tm[6*(iState)+3*0+2] == 0 {
-			undefinedTransitionCount += 1
-		} else {
-			definedTransitionCount += 1
+	var wg sync.WaitGroup
+	for _, w := range e.scheduler.workers {
+		wg.Add(1)
+		go func(w *worker) {
+			e.runWorker(w)
+			wg.Done()
+		}(w)
+	}
+
+	if e.Verbose {
+		done := make(chan bool)
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+
+		ticker := time.NewTicker(time.Duration(e.LogFreq))
+		defer ticker.Stop()
+
+	logging:
+		for {
+			select {
+			case <-ticker.C:
+				e.logProgress()
+			case <-done:
+				break logging
+			}
 		}
+		e.logProgress()
+	} else {
+		wg.Wait()
+	}
 
-		if tm[6*(iState)+3*1+2] == 0 {
-			undefinedTransitionCount += 1
-		} else {
-			definedTransitionCount += 1
+	if tm == (TM{}) && e.CheckpointPath != "" {
+		e.logError("Cannot write checkpoint", e.finishCheckpoint())
+	}
+
+	return e.Report()
+}
+
+// Current metrics of the enumeration
+func (e *Enumerator) Report() Report {
+	report := Report{Metrics: e.metrics, RunTime: time.Since(e.timeStart)}
+
+	if e.scheduler != nil {
+		report.NbWorkers = len(e.scheduler.workers)
+		for _, w := range e.scheduler.workers {
+			w.mutexMetrics.Lock()
+			report.Metrics.add(w.metrics)
+			report.NbStolenTasks += w.nbStolenTasks
+			w.mutexMetrics.Unlock()
+		}
+	}
+
+	return report
+}
+
+func (e *Enumerator) logProgress() {
+	r := e.Report()
+	fmt.Printf("run time: %s\ntotal: %d\npruned: %d (%.2f)\nhalt: %d (%.2f)\nnon halt: %d (%.2f)\nundecided time: %d (%.2f)\n"+
+		"undecided space: %d (%.2f)\nbb est.: %d\nbb space est.: %d\nrun/sec: %f\nworkers: %d\nstolen tasks: %d\n\n",
+		r.RunTime, r.NbMachineSeen,
+		r.NbMachinePruned, float64(r.NbMachinePruned)/float64(r.NbMachineSeen),
+		r.NbHaltingMachines, float64(r.NbHaltingMachines)/float64(r.NbMachineSeen),
+		r.NbNonHaltingMachines, float64(r.NbNonHaltingMachines)/float64(r.NbMachineSeen),
+		r.NbUndecidedTime, float64(r.NbUndecidedTime)/float64(r.NbMachineSeen),
+		r.NbUndecidedSpace, float64(r.NbUndecidedSpace)/float64(r.NbMachineSeen),
+		r.MaxNbSteps, r.MaxSpace, float64(r.NbMachineSeen)/r.RunTime.Seconds(),
+		r.NbWorkers, r.NbStolenTasks)
+}
+
+// Logs the best halting machines of a task if they are BB record holders
+func (e *Enumerator) logRecords(view *recordView, localMaxNbSteps int, localBestTimeHaltingMachine TM,
+	localMaxSpace int, localBestSpaceHaltingMachine TM) {
+
+	// The worker's view of the records is never above the actual ones, most
+	// tasks can be skipped without taking the lock
+	if localMaxNbSteps < view.steps && localMaxSpace <= view.space {
+		return
+	}
+
+	e.mutexRecords.Lock()
+	defer e.mutexRecords.Unlock()
+
+	nbStates := e.NbStates
+	nbSymbols := e.NbSymbols
+
+	if localMaxNbSteps >= e.recordSteps {
+		e.BBRecordLog.Write([]byte(fmt.Sprintf("*TIME %d SPACE %d\n%s\n",
+			localMaxNbSteps, localMaxSpace,
+			localBestTimeHaltingMachine.ToAsciiTable(nbStates, nbSymbols))))
+	} else if localMaxSpace > e.recordSpace {
+		// The comparison above was changed from >= to > because every machine that used all available memory would be recorded as a champion and that got annoying
+		e.BBRecordLog.Write([]byte(fmt.Sprintf("TIME %d *SPACE %d\n%s\n",
+			localMaxNbSteps, localMaxSpace,
+			localBestSpaceHaltingMachine.ToAsciiTable(nbStates, nbSymbols))))
+	}
+
+	e.recordSteps = MaxI(e.recordSteps, localMaxNbSteps)
+	e.recordSpace = MaxI(e.recordSpace, localMaxSpace)
+	view.steps = e.recordSteps
+	view.space = e.recordSpace
+}
+
+func (e *Enumerator) simulate(tm TM) (haltStatus HaltStatus, after_state byte, after_read byte,
+	steps_count int, space_count int, preperiod int, period int) {
+
+	switch e.Backend {
+	case SIMULATION_GO:
+		if e.DetectCycles {
+			return simulateDetectCycles(tm, e.NbSymbols, e.LimitTime, e.LimitSpace, e.Boundary)
+		}
+		haltStatus, after_state, after_read, steps_count, space_count = simulate(tm, e.NbSymbols, e.LimitTime, e.LimitSpace, e.BBtUpperBound, e.Boundary)
+	case SIMULATION_C:
+		haltStatus, after_state, after_read, steps_count, space_count = simulate_C_wrapper(tm, e.NbSymbols, e.LimitTime, e.LimitSpace, e.BBtUpperBound, e.Boundary)
+	}
+	return
+}
+
+// Sink receiving the records of the machines with the given status
+func (e *Enumerator) sink(haltStatus HaltStatus) ResultSink {
+	switch haltStatus {
+	case HALT:
+		return e.HaltingSink
+	case UNDECIDED_TIME:
+		return e.UndecidedTimeSink
+	case UNDECIDED_SPACE:
+		return e.UndecidedSpaceSink
+	}
+	return DiscardSink{}
+}
+
+// Candidate target states for the undefined transition of `state`: all states
+// up to the first completely undefined one, as in
+// http://turbotm.de/~heiner/BB/mabu90.html#Enumeration
+// Returns nil when it is the last undefined transition, which is left
+// undefined (halting)
+func targetStates(nbStates byte, nbSymbols byte, tm TM, state byte) []byte {
+	var target_states []byte
+	var undefinedTransitionCount byte
+
+	for iState := 0; iState < int(nbStates); iState += 1 {
+		target_states = append(target_states, byte(iState+1))
+
+		completelyUndefined := true
+		for iRead := byte(0); iRead < nbSymbols; iRead += 1 {
+			if tm[transitionIndex(nbSymbols, byte(iState+1), iRead)+2] == 0 {
+				undefinedTransitionCount += 1
+			} else {
+				completelyUndefined = false
+			}
 		}
 
 		// The following allows to take the min of the completely undefined states
 		// last condition very important to discard current state (which is about to not be completely undefined)
-		if tm[6*(iState)+3*0+2] == 0 && tm[6*(iState)+3*1+2] == 0 && byte(iState+1) != state {
+		if completelyUndefined && byte(iState+1) != state {
 			break
 		}
 	}
 
 	// Last transition
-	if target_states[nbStates-1] != 0 && undefinedTransitionCount == 1 {
-		return
+	if len(target_states) == int(nbStates) && undefinedTransitionCount == 1 {
+		return nil
 	}
 
-	isRoot := definedTransitionCount == 0
+	return target_states
+}
+
+// Enumerates the children of the task, those which halt become new tasks
+func (e *Enumerator) enumerate(w *worker, t task) {
+	tm, state, read, sub := t.tm, t.state, t.read, t.sub
 
-	var target_state byte
+	nbStates := e.NbStates
+	nbSymbols := e.NbSymbols
+
+	definedTransitionCount := 0
+	for i := 2; i < TM_SIZE; i += 3 {
+		if tm[i] != 0 {
+			definedTransitionCount += 1
+		}
+	}
+	isRoot := definedTransitionCount == 0
 
 	var localNbMachineSeen int
 	var localNbMachinePruned int
@@ -114,62 +406,83 @@ func Enumerate(nbStates byte, tm TM, state byte, read byte,
 	var localBestSpaceHaltingMachine TM
 	var localMaxSpace int
 
-	var loopIndex int
+	target_states := targetStates(nbStates, nbSymbols, tm, state)
+	if target_states == nil {
+		return
+	}
 
-	var wg sync.WaitGroup
-	for _, target_state = range target_states {
-		if target_state == 0 {
-			break
-		}
+	for _, target_state := range target_states {
 
 		var move byte
 		for move = 0; move <= 1; move += 1 {
 
 			var write byte
-			for write = 0; write <= 1; write += 1 {
+			for write = 0; write < nbSymbols; write += 1 {
 
 				var newTm TM = tm
-				newTm[(state-1)*6+read*3] = write
-				newTm[(state-1)*6+read*3+1] = move
-				newTm[(state-1)*6+read*3+2] = target_state
+				newTm[transitionIndex(nbSymbols, state, read)] = write
+				newTm[transitionIndex(nbSymbols, state, read)+1] = move
+				newTm[transitionIndex(nbSymbols, state, read)+2] = target_state
+
+				// Root of a task (see partition.go), machines above the task
+				// depth are only counted by task 0
+				depth := definedTransitionCount + 1
+				if depth == e.TaskDepth && !e.isMyTask(e.TaskID(newTm)) {
+					continue
+				}
+				counted := depth >= e.TaskDepth || e.isMyTask(0)
 
-				if !isRoot && ActivateFiltering && pruneTM(nbStates, newTm, state, read) {
-					localNbMachinePruned += 1
+				if !isRoot && e.ActivateFiltering && pruneTM(nbStates, nbSymbols, newTm, state, read, e.Boundary) {
+					if counted {
+						localNbMachinePruned += 1
+					}
+					continue
+				}
+
+				// Root of a checkpointed subtree
+				child := sub
+				if e.CheckpointPath != "" && depth == e.CheckpointDepth {
+					if e.isSubtreeCompleted(newTm) {
+						continue
+					}
+					child = newSubtree(newTm)
+				}
+
+				// Only simulated to reach the roots of our tasks
+				if !counted {
+					haltStatus, after_state, after_read, steps_count, space_count, _, _ := e.simulate(newTm)
+					if haltStatus == HALT && after_state != H {
+						e.scheduler.push(w, task{tm: newTm, state: after_state, read: after_read,
+							steps_count: steps_count, space_count: space_count, sub: child})
+					} else if child != sub {
+						e.completeSubtree(child)
+					}
 					continue
 				}
 
 				localNbMachineSeen += 1
 
-				var haltStatus HaltStatus
-				var after_state byte
-				var after_read byte
-				var steps_count int
-				var space_count int
+				haltStatus, after_state, after_read, steps_count, space_count, preperiod, period := e.simulate(newTm)
+				record := Record{
+					TM:         newTm,
+					NbStates:   nbStates,
+					NbSymbols:  nbSymbols,
+					HaltStatus: haltStatus,
+					EndState:   after_state,
+					Read:       after_read,
+					StepsCount: steps_count,
+					SpaceCount: space_count,
+					TapeLength: e.LimitSpace,
+				}
 
-				switch simulation_backend {
-				case SIMULATION_GO:
-					haltStatus, after_state, after_read, steps_count, space_count = simulate(newTm, SimulationLimitTime, SimulationLimitSpace)
-					break
-				case SIMULATION_C:
-					haltStatus, after_state, after_read, steps_count, space_count = simulate_C_wrapper(newTm, SimulationLimitTime, SimulationLimitSpace)
-					break
+				if child != sub {
+					child.metrics.addMachine(haltStatus, newTm, steps_count, space_count)
 				}
 
 				switch haltStatus {
 				case HALT:
 
-					// Task Divisor
-					if isRoot {
-
-						if loopIndex/(8/TaskDivisor) != TaskDivisorMe {
-							loopIndex += 1
-							continue
-						} else {
-							loopIndex += 1
-						}
-					}
-
-					if steps_count > localMaxNbSteps {
+					if isBetterChampion(newTm, steps_count, localBestTimeHaltingMachine, localMaxNbSteps) {
 						localBestTimeHaltingMachine = newTm
 					}
 
@@ -181,96 +494,85 @@ func Enumerate(nbStates byte, tm TM, state byte, read byte,
 					localMaxSpace = MaxI(localMaxSpace, space_count)
 					localNbHalt += 1
 
-					if ListAll {
+					if e.ListAll {
 						fmt.Printf("Time: %d \nSpace: %d\n%s\n",
 							steps_count, space_count,
-							newTm.ToAsciiTable(nbStates))
+							newTm.ToAsciiTable(nbStates, nbSymbols))
 					}
 
-					HaltingLog.Write(newTm[:])
+					e.writeRecord(child, record)
 
-					if slow_down == 0 {
-						wg.Add(1)
-
-						go func() {
-							Enumerate(nbStates, newTm, after_state, after_read, steps_count, space_count,
-								SlowDownInit, simulation_backend)
-							wg.Done()
-						}()
-					} else {
-						Enumerate(nbStates, newTm, after_state, after_read, steps_count, space_count,
-							slow_down-1, simulation_backend)
+					// The machine halted on a wall (BOUNDARY_HALT) without
+					// reaching an undefined transition: nothing to extend
+					if after_state == H {
+						break
 					}
-					break
+
+					e.scheduler.push(w, task{
+						tm:          newTm,
+						state:       after_state,
+						read:        after_read,
+						steps_count: steps_count,
+						space_count: space_count,
+						sub:         child,
+					})
+					continue
 
 				case NO_HALT:
 					localNbNoHalt += 1
-					if ListAll {
-						fmt.Printf("Does not halt\n%s\n", newTm.ToAsciiTable(nbStates))
+					if e.ListAll {
+						if e.DetectCycles {
+							fmt.Printf("Does not halt (preperiod: %d, period: %d)\n%s\n",
+								preperiod, period, newTm.ToAsciiTable(nbStates, nbSymbols))
+						} else {
+							fmt.Printf("Does not halt\n%s\n", newTm.ToAsciiTable(nbStates, nbSymbols))
+						}
 					}
 					break
 
 				case UNDECIDED_TIME:
 					localNbUndecidedTime += 1
-					UndecidedTimeLog.Write(newTm[:])
-					if ListAll {
-						fmt.Printf("Undecided (time limit exceeded)\n%s\n", newTm.ToAsciiTable(nbStates))
+					e.writeRecord(child, record)
+					if e.ListAll {
+						fmt.Printf("Undecided (time limit exceeded)\n%s\n", newTm.ToAsciiTable(nbStates, nbSymbols))
 					}
 					break
 
 				case UNDECIDED_SPACE:
 					localNbUndecidedSpace += 1
-					UndecidedSpaceLog.Write(newTm[:])
-					if ListAll {
-						fmt.Printf("Undecided (space limit exceeded)\n%s\n", newTm.ToAsciiTable(nbStates))
+					e.writeRecord(child, record)
+					if e.ListAll {
+						fmt.Printf("Undecided (space limit exceeded)\n%s\n", newTm.ToAsciiTable(nbStates, nbSymbols))
 					}
 					break
 				}
+
+				// Leaf of the enumeration tree
+				if child != sub {
+					e.completeSubtree(child)
+				}
 			}
 
 		}
 
 	}
-	wg.Wait()
-
-	mutexMetrics.Lock()
-	NbMachineSeen += localNbMachineSeen
-	NbMachinePruned += localNbMachinePruned
-	NbHaltingMachines += localNbHalt
-	NbNonHaltingMachines += localNbNoHalt
-	NbUndecidedTime += localNbUndecidedTime
-	NbUndecidedSpace += localNbUndecidedSpace
-
-	if localMaxNbSteps >= MaxNbSteps {
-		BBRecordLog.Write([]byte(fmt.Sprintf("*TIME %d SPACE %d\n%s\n",
-			localMaxNbSteps, localMaxSpace,
-			localBestTimeHaltingMachine.ToAsciiTable(nbStates))))
-	} else if localMaxSpace > MaxSpace {
-		// The comparison above was changed from >= to > because every machine that used all available memory would be recorded as a champion and that got annoying
-		BBRecordLog.Write([]byte(fmt.Sprintf("TIME %d *SPACE %d\n%s\n",
-			localMaxNbSteps, localMaxSpace,
-			localBestSpaceHaltingMachine.ToAsciiTable(nbStates))))
-	}
-
-	MaxNbSteps = MaxI(localMaxNbSteps, MaxNbSteps)
-	MaxSpace = MaxI(localMaxSpace, MaxSpace)
-	MaxNbGoRoutines = MaxI(MaxNbGoRoutines, runtime.NumGoroutine())
-
-	if Verbose && (time.Since(lastLogTime) >= time.Duration(LogFreq) || isRoot) {
-		lastLogTime = time.Now()
-		fmt.Printf("run time: %s\ntotal: %d\npruned: %d (%.2f)\nhalt: %d (%.2f)\nnon halt: %d (%.2f)\nundecided time: %d (%.2f)\n"+
-			"undecided space: %d (%.2f)\nbb est.: %d\nbb space est.: %d\nrun/sec: %f\nmax go routines: %d\n\n",
-			time.Since(TimeStart), NbMachineSeen,
-			NbMachinePruned, float64(NbMachinePruned)/float64(NbMachineSeen),
-			NbHaltingMachines, float64(NbHaltingMachines)/float64(NbMachineSeen),
-			NbNonHaltingMachines, float64(NbNonHaltingMachines)/float64(NbMachineSeen),
-			NbUndecidedTime, float64(NbUndecidedTime)/float64(NbMachineSeen),
-			NbUndecidedSpace, float64(NbUndecidedSpace)/float64(NbMachineSeen),
-			MaxNbSteps, MaxSpace, float64(NbMachineSeen)/time.Since(TimeStart).Seconds(),
-			MaxNbGoRoutines)
 
+	metrics := Metrics{
+		NbMachineSeen:        localNbMachineSeen,
+		NbMachinePruned:      localNbMachinePruned,
+		NbHaltingMachines:    localNbHalt,
+		NbNonHaltingMachines: localNbNoHalt,
+		NbUndecidedTime:      localNbUndecidedTime,
+		NbUndecidedSpace:     localNbUndecidedSpace,
+		MaxNbSteps:           localMaxNbSteps,
+		MaxSpace:             localMaxSpace,
+		BBChampion:           localBestTimeHaltingMachine,
 	}
 
-	mutexMetrics.Unlock()
+	if sub != nil {
+		sub.addMetrics(metrics)
+	}
+	w.addMetrics(metrics)
 
+	e.logRecords(&w.records, localMaxNbSteps, localBestTimeHaltingMachine, localMaxSpace, localBestSpaceHaltingMachine)
 }
@@ -0,0 +1,149 @@
+// Here we test the enumeration of TMs
+package bbchallenge
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "regenerate the golden files of the enumeration counts in testdata")
+
+func getSmallRunParameters(nbStates byte, limitSpace int, boundary BoundaryMode) RunParameters {
+	return RunParameters{
+		NbStates:          nbStates,
+		NbSymbols:         2,
+		LimitTime:         UpperBound(nbStates, 2, limitSpace),
+		LimitSpace:        limitSpace,
+		Boundary:          boundary,
+		ActivateFiltering: true,
+		Backend:           SIMULATION_GO,
+		TaskDivisor:       1,
+	}
+}
+
+// Several enumerators running at the same time must not interfere
+func TestConcurrentEnumerators(t *testing.T) {
+	var params []RunParameters
+	for boundary := BOUNDARY_STAY; boundary <= BOUNDARY_WRAP; boundary += 1 {
+		params = append(params, getSmallRunParameters(3, 3, boundary))
+	}
+
+	var expected []Report
+	var expectedHalting [][]byte
+	for _, p := range params {
+		var halting bytes.Buffer
+		e := NewEnumerator(p)
+		e.HaltingSink = LegacySink{&lockedWriter{w: &halting}}
+		expected = append(expected, e.Enumerate())
+		expectedHalting = append(expectedHalting, halting.Bytes())
+	}
+
+	reports := make([]Report, len(params))
+	halting := make([]bytes.Buffer, len(params))
+	var wg sync.WaitGroup
+	for i, p := range params {
+		wg.Add(1)
+		go func(i int, p RunParameters) {
+			e := NewEnumerator(p)
+			e.HaltingSink = LegacySink{&lockedWriter{w: &halting[i]}}
+			reports[i] = e.Enumerate()
+			wg.Done()
+		}(i, p)
+	}
+	wg.Wait()
+
+	for i, p := range params {
+		if reports[i].Metrics != expected[i].Metrics {
+			t.Error(p.Boundary, reports[i].Metrics, expected[i].Metrics)
+		}
+		if halting[i].Len() != len(expectedHalting[i]) {
+			t.Error(p.Boundary, halting[i].Len(), len(expectedHalting[i]))
+		}
+		if expected[i].NbMachineSeen == 0 {
+			t.Error(p.Boundary, "no machine enumerated")
+		}
+	}
+}
+
+// bytes.Buffer is not safe for concurrent writes, which happen as soon as
+// the enumeration starts go routines
+type lockedWriter struct {
+	mutex sync.Mutex
+	w     *bytes.Buffer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.w.Write(p)
+}
+
+// Counts of the enumeration (per halt status, pruned machines and BB
+// champion) for small numbers of states over a range of tape lengths, with
+// and without filtering. They are compared to testdata/enumerate_*.golden so
+// that changes to pruning or to the choice of target states do not go
+// unnoticed. After an intended change, regenerate them with
+// `go test ./lib_bbchallenge -run TestEnumerateGolden -update`.
+func TestEnumerateGolden(t *testing.T) {
+	sweeps := []struct {
+		nbStates byte
+		from, to int
+	}{
+		{2, 1, 6},
+		{3, 1, 5},
+		{4, 1, 3},
+	}
+
+	for _, sweep := range sweeps {
+		for _, filtering := range []bool{true, false} {
+			params := getSmallRunParameters(sweep.nbStates, 1, BOUNDARY_STAY)
+			params.ActivateFiltering = filtering
+			params.LimitTime = math.MaxInt // Up to the upper bound of each tape length
+
+			rows := Sweep(params, sweep.from, sweep.to, nil)
+			var got bytes.Buffer
+			if err := WriteSweepCSV(&got, rows, sweep.nbStates, 2); err != nil {
+				t.Fatal(err)
+			}
+
+			name := fmt.Sprintf("enumerate_%d_states.golden", sweep.nbStates)
+			if !filtering {
+				name = fmt.Sprintf("enumerate_%d_states_nf.golden", sweep.nbStates)
+			}
+			path := filepath.Join("testdata", name)
+
+			if *updateGolden {
+				if err := ioutil.WriteFile(path, got.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+
+			expected, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err, "(regenerate the golden files with -update)")
+			}
+			gotLines := strings.Split(got.String(), "\n")
+			expectedLines := strings.Split(string(expected), "\n")
+			for i := 0; i < len(gotLines) || i < len(expectedLines); i += 1 {
+				var gotLine, expectedLine string
+				if i < len(gotLines) {
+					gotLine = gotLines[i]
+				}
+				if i < len(expectedLines) {
+					expectedLine = expectedLines[i]
+				}
+				if gotLine != expectedLine {
+					t.Errorf("%s line %d:\n got      %s\n expected %s", path, i+1, gotLine, expectedLine)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Here we simulate a single machine in detail, to inspect it: final
+// configuration and space-time diagram
+package bbchallenge
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// Outcome of Simulate
+type Simulation struct {
+	TM        TM
+	NbStates  byte
+	NbSymbols byte
+	Boundary  BoundaryMode
+
+	// Same as the results of `simulateDetectCycles`
+	HaltStatus HaltStatus
+	EndState   byte
+	Read       byte
+	Steps      int
+	Space      int
+	Preperiod  int
+	Period     int
+
+	// Final configuration, after Steps steps
+	State byte
+	Head  int
+	Tape  []byte
+
+	// First rows of the space-time diagram: the configuration before each
+	// step, followed by the final one
+	Diagram          []DiagramRow
+	DiagramTruncated bool
+}
+
+type DiagramRow struct {
+	State byte
+	Head  int
+	Tape  []byte
+}
+
+// Runs the machine from a blank tape of length limitSpace, deciding non-halting
+// with cycle detection (see simulateDetectCycles), and records at most
+// maxDiagramRows rows of its space-time diagram. The configurations are found
+// by running the machine a second time, which is skipped when maxDiagramRows
+// is 0: the final configuration is then not recorded either.
+func Simulate(tm TM, nbStates byte, nbSymbols byte, limitTime int, limitSpace int, boundary BoundaryMode, maxDiagramRows int) Simulation {
+	s := Simulation{TM: tm, NbStates: nbStates, NbSymbols: nbSymbols, Boundary: boundary}
+	s.HaltStatus, s.EndState, s.Read, s.Steps, s.Space, s.Preperiod, s.Period =
+		simulateDetectCycles(tm, nbSymbols, limitTime, limitSpace, boundary)
+	if maxDiagramRows == 0 {
+		return s
+	}
+
+	// Replay the run to get the configurations. The last step of a halting
+	// run does not change the configuration when it reaches an undefined
+	// transition, and ends in state H when the machine halts on the wall.
+	c := newConfiguration(nbSymbols, limitSpace)
+	replay := s.Steps
+	if s.HaltStatus == HALT && s.EndState != H {
+		replay -= 1
+	}
+
+	addRow := func() {
+		if len(s.Diagram) == maxDiagramRows {
+			s.DiagramTruncated = true
+			return
+		}
+		s.Diagram = append(s.Diagram, DiagramRow{c.state, c.head, append([]byte(nil), c.tape...)})
+	}
+	for i := 0; i < replay && c.state != H; i += 1 {
+		addRow()
+		result, _ := c.step(tm, boundary)
+		if result == STEP_WALL_HALT {
+			c.state = H
+		}
+		if result != STEP_OK {
+			break
+		}
+	}
+	addRow()
+
+	s.State, s.Head, s.Tape = c.state, c.head, c.tape
+	return s
+}
+
+func stateLetter(state byte) byte {
+	if state == H {
+		return DEFAULT_HALT_SYMBOL
+	}
+	return 'A' + state - 1
+}
+
+// Returns the tape with the head shown as State[symbol], e.g. ` 1  0 B[1] 0 `
+func TapeString(tape []byte, head int, state byte) string {
+	var b strings.Builder
+
+	cell := func(i int, symbol string) {
+		if i == head {
+			fmt.Fprintf(&b, "%c[%s]", stateLetter(state), symbol)
+		} else {
+			fmt.Fprintf(&b, " %s ", symbol)
+		}
+	}
+
+	for i, symbol := range tape {
+		cell(i, fmt.Sprint(symbol))
+	}
+	return b.String()
+}
+
+// Text summary of the simulation, followed by its diagram if asked
+func (s Simulation) WriteText(w io.Writer, withDiagram bool) error {
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintln(out, "Machine:", s.TM.ToCompactString(s.NbStates, s.NbSymbols))
+	fmt.Fprintln(out, "Halt status:", s.HaltStatus)
+	if s.HaltStatus == HALT {
+		if s.EndState == H {
+			fmt.Fprintln(out, "Halted in state", string(rune(DEFAULT_HALT_SYMBOL)))
+		} else {
+			fmt.Fprintf(out, "Undefined transition %c%d\n", stateLetter(s.EndState), s.Read)
+		}
+	}
+	if s.HaltStatus == NO_HALT && s.Period > 0 {
+		fmt.Fprintln(out, "Cycle:", s.Period, "steps after", s.Preperiod, "steps")
+	}
+	fmt.Fprintln(out, "Steps:", s.Steps)
+	fmt.Fprintln(out, "Space:", s.Space)
+	if s.Tape != nil {
+		fmt.Fprintln(out, "Final tape:", TapeString(s.Tape, s.Head, s.State))
+	}
+
+	if withDiagram {
+		fmt.Fprintln(out)
+		width := len(fmt.Sprint(len(s.Diagram)))
+		for i, row := range s.Diagram {
+			fmt.Fprintf(out, "%*d %s\n", width, i, TapeString(row.Tape, row.Head, row.State))
+		}
+		if s.DiagramTruncated {
+			fmt.Fprintln(out, "...")
+		}
+	}
+	return out.Flush()
+}
+
+// Colors of the symbols in the diagram, from blank (white) to black, the
+// head is colored by state
+var diagramSymbolColors = []color.RGBA{
+	{255, 255, 255, 255}, {40, 40, 40, 255}, {120, 120, 200, 255}, {200, 120, 120, 255},
+}
+
+var diagramStateColors = []color.RGBA{
+	{230, 60, 60, 255}, {60, 180, 75, 255}, {255, 200, 25, 255}, {0, 130, 200, 255},
+	{245, 130, 48, 255}, {145, 30, 180, 255}, {70, 240, 240, 255}, {240, 50, 230, 255},
+}
+
+func diagramStateColor(state byte) color.RGBA {
+	if state == H || state == 0 {
+		return color.RGBA{0, 0, 0, 255}
+	}
+	return diagramStateColors[(state-1)%MAX_STATES]
+}
+
+// Calls cell for each cell of the diagram, in rows and columns, with its
+// color
+func (s Simulation) diagramCells(cell func(row int, column int, c color.RGBA)) (nbRows int, nbColumns int) {
+	for r, row := range s.Diagram {
+		nbColumns = len(row.Tape)
+		for column := 0; column < nbColumns; column += 1 {
+			c := diagramSymbolColors[row.Tape[column]%MAX_SYMBOLS]
+			if column == row.Head {
+				c = diagramStateColor(row.State)
+			}
+			cell(r, column, c)
+		}
+	}
+	return len(s.Diagram), nbColumns
+}
+
+// Writes the diagram as a PNG image, one row per step and one column per
+// cell, each cell being cellSize pixels wide
+func (s Simulation) WriteDiagramPNG(w io.Writer, cellSize int) error {
+	nbRows, nbColumns := s.diagramCells(func(int, int, color.RGBA) {})
+	img := image.NewRGBA(image.Rect(0, 0, nbColumns*cellSize, nbRows*cellSize))
+
+	s.diagramCells(func(row int, column int, c color.RGBA) {
+		for y := row * cellSize; y < (row+1)*cellSize; y += 1 {
+			for x := column * cellSize; x < (column+1)*cellSize; x += 1 {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	})
+	return png.Encode(w, img)
+}
+
+// Same as WriteDiagramPNG, as an SVG image
+func (s Simulation) WriteDiagramSVG(w io.Writer, cellSize int) error {
+	var cells strings.Builder
+	nbRows, nbColumns := s.diagramCells(func(row int, column int, c color.RGBA) {
+		if c == diagramSymbolColors[0] {
+			return
+		}
+		fmt.Fprintf(&cells, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"#%02x%02x%02x\"/>\n",
+			column*cellSize, row*cellSize, cellSize, cellSize, c.R, c.G, c.B)
+	})
+
+	_, err := fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" shape-rendering=\"crispEdges\">\n"+
+		"<rect width=\"100%%\" height=\"100%%\" fill=\"white\"/>\n%s</svg>\n",
+		nbColumns*cellSize, nbRows*cellSize, cells.String())
+	return err
+}
@@ -0,0 +1,72 @@
+// Here we test the detailed simulation of a single machine
+package bbchallenge
+
+import (
+	"bytes"
+	"image/png"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSimulate(t *testing.T) {
+	s := Simulate(getBB2Winner(), 2, 2, 1000, 5, BOUNDARY_STAY, 100)
+	if s.HaltStatus != HALT || s.EndState != H || s.Steps != 4 || s.Space != 2 ||
+		s.State != H || s.Head != 1 || !reflect.DeepEqual(s.Tape, []byte{1, 1, 0, 0, 0}) {
+		t.Error(s)
+	}
+	if len(s.Diagram) != 5 || s.DiagramTruncated || s.Diagram[2].State != 1 || s.Diagram[2].Head != 0 {
+		t.Error(s.Diagram)
+	}
+
+	// The undefined transition is not a step of the diagram
+	s = Simulate(getThreeSymbolTM(), 2, 3, 1000, 2, BOUNDARY_STAY, 2)
+	if s.HaltStatus != HALT || s.EndState != 1 || s.Read != 2 || s.Steps != 4 ||
+		len(s.Diagram) != 2 || !s.DiagramTruncated || s.State != 1 || s.Tape[s.Head] != 2 {
+		t.Error(s)
+	}
+
+	// Halting on the wall ends in state H, as recorded by the enumeration
+	s = Simulate(getBB2Winner(), 2, 2, 1000, 5, BOUNDARY_HALT, 100)
+	if s.HaltStatus != HALT || s.EndState != H || s.Steps != 3 || s.State != H ||
+		len(s.Diagram) != 4 || s.Diagram[3].State != H {
+		t.Error(s)
+	}
+
+	// Without diagram the run is not replayed
+	s = Simulate(getBB2Winner(), 2, 2, 1000, 5, BOUNDARY_STAY, 0)
+	if s.HaltStatus != HALT || s.Steps != 4 || s.Space != 2 || s.Tape != nil || len(s.Diagram) != 0 {
+		t.Error(s)
+	}
+
+	s = Simulate(getBB2Winner(), 2, 2, 2, 5, BOUNDARY_STAY, 100)
+	if s.HaltStatus != UNDECIDED_TIME || len(s.Diagram) != s.Steps+1 {
+		t.Error(s)
+	}
+
+	var text bytes.Buffer
+	s = Simulate(getBB2Winner(), 2, 2, 1000, 3, BOUNDARY_STAY, 100)
+	if err := s.WriteText(&text, true); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "Steps: 4") || !strings.Contains(text.String(), "2 A[1] 1  0 ") {
+		t.Error(text.String())
+	}
+
+	var img bytes.Buffer
+	if err := s.WriteDiagramPNG(&img, 3); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := png.Decode(&img)
+	if err != nil || decoded.Bounds().Dx() != 3*3 || decoded.Bounds().Dy() != 3*len(s.Diagram) {
+		t.Error(decoded.Bounds(), err)
+	}
+
+	var svg bytes.Buffer
+	if err := s.WriteDiagramSVG(&svg, 3); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(svg.String(), "<svg") || !strings.HasSuffix(svg.String(), "</svg>\n") {
+		t.Error(svg.String())
+	}
+}
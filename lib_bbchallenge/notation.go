@@ -0,0 +1,140 @@
+// Here we read and write machines in the standard text notation, e.g.
+// 1RB1LC_1RC1RB_1RD0LE_1LA1LD_1RZ0LA for the BB5 champion: the transitions of
+// each state (written symbol, direction, next state) separated by
+// underscores, with `---` for undefined transitions
+package bbchallenge
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Letter of the halt state, H is taken by the 8th state
+const DEFAULT_HALT_SYMBOL = 'Z'
+
+const undefinedTransitionText = "---"
+
+func transitionToText(write byte, move byte, goTo byte, haltSymbol byte) string {
+	if goTo == 0 {
+		return undefinedTransitionText
+	}
+
+	direction := "R"
+	if move == L {
+		direction = "L"
+	}
+
+	next := haltSymbol
+	if goTo != H {
+		next = 'A' + goTo - 1
+	}
+	return fmt.Sprintf("%d%s%c", write, direction, next)
+}
+
+// Text notation of the machine, with the given letter for the halt state
+func (tm TM) ToText(nbStates byte, nbSymbols byte, haltSymbol byte) string {
+	var states []string
+	for state := byte(1); state <= nbStates; state += 1 {
+		var transitions string
+		for read := byte(0); read < nbSymbols; read += 1 {
+			i := transitionIndex(nbSymbols, state, read)
+			transitions += transitionToText(tm[i], tm[i+1], tm[i+2], haltSymbol)
+		}
+		states = append(states, transitions)
+	}
+	return strings.Join(states, "_")
+}
+
+// Number of states of a 2-symbol machine: the last state that has a defined
+// transition or that is reached by one
+func (tm TM) NbStates() byte {
+	nbStates := byte(1)
+	for state := byte(1); state <= MAX_STATES; state += 1 {
+		for read := byte(0); read < 2; read += 1 {
+			i := transitionIndex(2, state, read)
+			if tm[i+2] != 0 {
+				nbStates = state
+			}
+			if tm[i+2] != H && tm[i+2] > nbStates {
+				nbStates = tm[i+2]
+			}
+		}
+	}
+	return nbStates
+}
+
+// Text notation of a 2-symbol machine, use ToText for other machines
+func (tm TM) String() string {
+	return tm.ToText(tm.NbStates(), 2, DEFAULT_HALT_SYMBOL)
+}
+
+// Reads a machine in text notation, the number of states and symbols are
+// given by the number and length of the groups of transitions
+func ParseTM(text string, haltSymbol byte) (tm TM, nbStates byte, nbSymbols byte, err error) {
+	groups := strings.Split(strings.TrimSpace(text), "_")
+
+	if len(groups) > MAX_STATES {
+		return tm, 0, 0, fmt.Errorf("machines have at most %d states", MAX_STATES)
+	}
+	nbStates = byte(len(groups))
+
+	if len(groups[0])%3 != 0 || len(groups[0]) < 6 || len(groups[0]) > 3*MAX_SYMBOLS {
+		return tm, 0, 0, fmt.Errorf("invalid transitions '%s', machines have 2 to %d symbols", groups[0], MAX_SYMBOLS)
+	}
+	nbSymbols = byte(len(groups[0]) / 3)
+
+	if haltSymbol >= 'A' && haltSymbol < 'A'+nbStates {
+		return tm, 0, 0, fmt.Errorf("halt symbol %c is the letter of a state", haltSymbol)
+	}
+
+	for s, group := range groups {
+		if len(group) != 3*int(nbSymbols) {
+			return tm, 0, 0, fmt.Errorf("state %c has %d characters instead of %d", 'A'+s, len(group), 3*nbSymbols)
+		}
+
+		state := byte(s + 1)
+		for read := byte(0); read < nbSymbols; read += 1 {
+			transition := group[3*read : 3*read+3]
+			i := transitionIndex(nbSymbols, state, read)
+			if err := parseTransition(transition, nbStates, nbSymbols, haltSymbol, tm[i:i+3]); err != nil {
+				return tm, 0, 0, fmt.Errorf("transition %c%d '%s': %v", 'A'+s, read, transition, err)
+			}
+		}
+	}
+	return tm, nbStates, nbSymbols, nil
+}
+
+func parseTransition(transition string, nbStates byte, nbSymbols byte, haltSymbol byte, encoded []byte) error {
+	if transition == undefinedTransitionText {
+		return nil
+	}
+
+	write := transition[0] - '0'
+	if write >= nbSymbols {
+		return errors.New("invalid written symbol")
+	}
+
+	var move byte
+	switch transition[1] {
+	case 'R':
+		move = R
+	case 'L':
+		move = L
+	default:
+		return errors.New("invalid direction")
+	}
+
+	var goTo byte
+	switch next := transition[2]; {
+	case next == haltSymbol:
+		goTo = H
+	case next >= 'A' && next < 'A'+nbStates:
+		goTo = next - 'A' + 1
+	default:
+		return errors.New("invalid next state")
+	}
+
+	encoded[0], encoded[1], encoded[2] = write, move, goTo
+	return nil
+}
@@ -0,0 +1,58 @@
+// Here we test the text notation of machines
+package bbchallenge
+
+import "testing"
+
+func TestTextNotation(t *testing.T) {
+	bb5 := "1RB1LC_1RC1RB_1RD0LE_1LA1LD_1RZ0LA"
+	if s := getBB5Winner().String(); s != bb5 {
+		t.Error(s)
+	}
+	if s := getBB2Winner().String(); s != "1RB1LB_1LA1RZ" {
+		t.Error(s)
+	}
+	if s := getEightStateTM().ToText(8, 2, '!'); s != "1RB---_1RC---_1RD---_1RE---_1RF---_1RG---_1RH---_1R!---" {
+		t.Error(s)
+	}
+
+	cases := []struct {
+		text       string
+		haltSymbol byte
+		tm         TM
+		nbStates   byte
+		nbSymbols  byte
+	}{
+		{bb5, 'Z', getBB5Winner(), 5, 2},
+		{"1RB1LB_1LA1RH", 'H', getBB2Winner(), 2, 2},
+		{"1RB2LA---_2LA2RB1RA", 'Z', getThreeSymbolTM(), 2, 3},
+		{getEightStateTM().String(), 'Z', getEightStateTM(), 8, 2},
+	}
+	for _, c := range cases {
+		tm, nbStates, nbSymbols, err := ParseTM(c.text, c.haltSymbol)
+		if err != nil || tm != c.tm || nbStates != c.nbStates || nbSymbols != c.nbSymbols {
+			t.Error(c.text, nbStates, nbSymbols, err)
+		}
+		if s := tm.ToText(nbStates, nbSymbols, c.haltSymbol); s != c.text {
+			t.Error(s, c.text)
+		}
+	}
+
+	// The machines of the enumeration have undefined transitions
+	undefined := "1RB---_1LA---"
+	if tm, _, _, err := ParseTM(undefined, 'Z'); err != nil || tm.String() != undefined {
+		t.Error(tm.String(), err)
+	}
+
+	invalid := []string{
+		"", "1RB", "1RB1LB_1LA", "1RB1LB_1LA1RC", "2RB1LB_1LA1RZ", "1XB1LB_1LA1RZ",
+		"1RB1LB_1LA1R-", "1RB1LB_1LA1RZ_1RA1RA_1RA1RA_1RA1RA_1RA1RA_1RA1RA_1RA1RA_1RA1RA",
+	}
+	for _, text := range invalid {
+		if _, _, _, err := ParseTM(text, 'Z'); err == nil {
+			t.Error(text, "is not a valid machine")
+		}
+	}
+	if _, _, _, err := ParseTM("1RB1LB_1LA1RB", 'B'); err == nil {
+		t.Error("B is a state")
+	}
+}
@@ -0,0 +1,207 @@
+// Here we split the enumeration tree in tasks that can be run independently
+package bbchallenge
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The tree is cut at TaskDepth: every machine with exactly TaskDepth defined
+// transitions is the root of a subtree which belongs to task
+// TaskID(root), a hash of the root modulo TaskDivisor. Task IDs only depend on
+// the machines, not on the order in which they are enumerated, so that they
+// are the same from one run, one version or one number of workers to the
+// other.
+// The machines above TaskDepth are enumerated by every task to reach the
+// roots but are only counted and written by task 0, so that the outputs of
+// all tasks add up to the whole enumeration.
+
+// Task of the subtree rooted at tm
+func (params RunParameters) TaskID(tm TM) int {
+	if params.TaskDivisor <= 1 {
+		return 0
+	}
+
+	// Roots only differ by a few bytes, the low bits of simpler hashes (FNV)
+	// do not mix them enough to balance power of 2 divisors
+	hash := sha256.Sum256(tm[:3*int(params.NbStates)*int(params.NbSymbols)])
+	return int(binary.BigEndian.Uint64(hash[:8]) % uint64(params.TaskDivisor))
+}
+
+// Whether task `id` is one of ours, all tasks are if MyTasks is empty
+func (params RunParameters) isMyTask(id int) bool {
+	if len(params.MyTasks) == 0 {
+		return true
+	}
+
+	for _, myTask := range params.MyTasks {
+		if myTask == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses a list of task IDs such as "3", "0-5" or "1,4,7-9"
+func ParseTaskList(s string, taskDivisor int) ([]int, error) {
+	var tasks []int
+	seen := make(map[int]bool)
+
+	for _, item := range strings.Split(s, ",") {
+		bounds := strings.Split(item, "-")
+		if len(bounds) > 2 {
+			return nil, errors.New("invalid task list '" + s + "', must be a list of task ids or ranges such as '1,4,7-9'")
+		}
+
+		from, errFrom := strconv.Atoi(bounds[0])
+		to, errTo := from, errFrom
+		if len(bounds) == 2 {
+			to, errTo = strconv.Atoi(bounds[1])
+		}
+		if errFrom != nil || errTo != nil || from > to {
+			return nil, errors.New("invalid task list '" + s + "', must be a list of task ids or ranges such as '1,4,7-9'")
+		}
+		if from < 0 || to >= taskDivisor {
+			return nil, errors.New("task ids must be >= 0 and < the task divisor which is " + strconv.Itoa(taskDivisor))
+		}
+
+		for id := from; id <= to; id += 1 {
+			if !seen[id] {
+				seen[id] = true
+				tasks = append(tasks, id)
+			}
+		}
+	}
+
+	sort.Ints(tasks)
+	return tasks, nil
+}
+
+// Estimated size of a task
+type TaskEstimate struct {
+	ID             int
+	NbRoots        int     // Number of subtrees of the task
+	EstimatedSize  float64 // Estimated number of machines, pruned ones excluded
+	EstimatedShare float64 // Share of the whole enumeration
+}
+
+// Estimates the size of each task. The tree is enumerated down to TaskDepth
+// and the size of each subtree is estimated with Knuth's estimator, averaged
+// over `nbProbes` random descents from its root (see probe).
+// The estimates are deterministic for a given seed.
+func EstimateTasks(params RunParameters, nbProbes int, seed int64) []TaskEstimate {
+	e := NewEnumerator(params)
+	random := rand.New(rand.NewSource(seed))
+
+	estimates := make([]TaskEstimate, MaxI(params.TaskDivisor, 1))
+	for id := range estimates {
+		estimates[id].ID = id
+	}
+
+	var total float64
+	var walk func(t task, depth int)
+	walk = func(t task, depth int) {
+		children, extended := e.children(t)
+		for _, child := range children {
+			if depth+1 < params.TaskDepth {
+				// Machines above the roots belong to task 0
+				estimates[0].EstimatedSize += 1
+				total += 1
+				continue
+			}
+
+			size := 1.0
+			if child.state != 0 {
+				var sum float64
+				for i := 0; i < nbProbes; i += 1 {
+					sum += e.probe(child, random)
+				}
+				size += sum / float64(nbProbes)
+			}
+
+			id := params.TaskID(child.tm)
+			estimates[id].NbRoots += 1
+			estimates[id].EstimatedSize += size
+			total += size
+		}
+
+		if depth+1 < params.TaskDepth {
+			for _, child := range extended {
+				walk(child, depth+1)
+			}
+		}
+	}
+	walk(task{state: 1}, 0)
+
+	for id := range estimates {
+		if total > 0 {
+			estimates[id].EstimatedShare = estimates[id].EstimatedSize / total
+		}
+	}
+	return estimates
+}
+
+// One random descent of Knuth's estimator, returns the estimated number of
+// strict descendants of t: the sum, over the nodes of the descent, of their
+// number of children times the number of choices made to reach them
+func (e *Enumerator) probe(t task, random *rand.Rand) float64 {
+	children, extended := e.children(t)
+	estimate := float64(len(children))
+	if len(extended) > 0 {
+		next := extended[random.Intn(len(extended))]
+		estimate += float64(len(extended)) * e.probe(next, random)
+	}
+	return estimate
+}
+
+// Simulates the children of t as Enumerate does, pruned ones excluded.
+// Children which halt on an undefined transition are also returned as tasks
+// to extend, the others have a zero state.
+func (e *Enumerator) children(t task) (children []task, extended []task) {
+	nbSymbols := e.NbSymbols
+	isRoot := t.tm == (TM{})
+
+	for _, target_state := range targetStates(e.NbStates, nbSymbols, t.tm, t.state) {
+		for move := byte(0); move <= 1; move += 1 {
+			for write := byte(0); write < nbSymbols; write += 1 {
+				newTm := t.tm
+				newTm[transitionIndex(nbSymbols, t.state, t.read)] = write
+				newTm[transitionIndex(nbSymbols, t.state, t.read)+1] = move
+				newTm[transitionIndex(nbSymbols, t.state, t.read)+2] = target_state
+
+				if !isRoot && e.ActivateFiltering && pruneTM(e.NbStates, nbSymbols, newTm, t.state, t.read, e.Boundary) {
+					continue
+				}
+
+				child := task{tm: newTm}
+				haltStatus, after_state, after_read, steps_count, space_count, _, _ := e.simulate(newTm)
+				if haltStatus == HALT && after_state != H {
+					child = task{tm: newTm, state: after_state, read: after_read,
+						steps_count: steps_count, space_count: space_count}
+					extended = append(extended, child)
+				}
+				children = append(children, child)
+			}
+		}
+	}
+	return children, extended
+}
+
+func TasksToAsciiTable(estimates []TaskEstimate) string {
+	var table [][]string
+	for _, estimate := range estimates {
+		table = append(table, []string{
+			strconv.Itoa(estimate.ID),
+			strconv.Itoa(estimate.NbRoots),
+			strconv.FormatFloat(estimate.EstimatedSize, 'e', 2, 64),
+			strconv.FormatFloat(100*estimate.EstimatedShare, 'f', 2, 64) + "%",
+		})
+	}
+
+	return AsciiTable(table, []string{"task", "subtrees", "est. machines", "est. share"})
+}
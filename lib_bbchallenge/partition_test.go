@@ -0,0 +1,106 @@
+// Here we test the partition of the enumeration in tasks
+package bbchallenge
+
+import (
+	"bytes"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseTaskList(t *testing.T) {
+	cases := map[string][]int{
+		"3":        {3},
+		"0-5":      {0, 1, 2, 3, 4, 5},
+		"1,4,7-9":  {1, 4, 7, 8, 9},
+		"9,2-3,2":  {2, 3, 9},
+		"11-11,10": {10, 11},
+	}
+	for s, expected := range cases {
+		tasks, err := ParseTaskList(s, 12)
+		if err != nil || !reflect.DeepEqual(tasks, expected) {
+			t.Error(s, tasks, err)
+		}
+	}
+
+	for _, s := range []string{"", "12", "-1", "3-1", "1-2-3", "a", "1,,2"} {
+		if _, err := ParseTaskList(s, 12); err == nil {
+			t.Error(s, "is not a valid task list")
+		}
+	}
+}
+
+// The outputs of all tasks add up to the whole enumeration, also when
+// checkpointed subtrees contain machines of several tasks
+func TestTasksAddUp(t *testing.T) {
+	params := getSmallRunParameters(3, 4, BOUNDARY_STAY)
+	whole := NewEnumerator(params).Enumerate()
+
+	params.TaskDivisor = 5
+	params.TaskDepth = 3
+	params.CheckpointDepth = 2
+
+	var sum Metrics
+	var halting bytes.Buffer
+	for id := 0; id < params.TaskDivisor; id += 1 {
+		params.MyTasks = []int{id}
+		e := NewEnumerator(params)
+		e.HaltingSink = LegacySink{&lockedWriter{w: &halting}}
+		e.CheckpointPath = filepath.Join(t.TempDir(), "checkpoint.json")
+		report := e.Enumerate()
+
+		if report.NbMachineSeen == 0 || report.NbMachineSeen == whole.NbMachineSeen {
+			t.Error(id, report.NbMachineSeen, whole.NbMachineSeen)
+		}
+		sum.add(report.Metrics)
+	}
+
+	if sum != whole.Metrics {
+		t.Error(sum, whole.Metrics)
+	}
+	if halting.Len() != LEGACY_TM_SIZE*whole.NbHaltingMachines {
+		t.Error(halting.Len(), whole.NbHaltingMachines)
+	}
+
+	// Several tasks in the same run
+	params.MyTasks = []int{1, 3}
+	several := NewEnumerator(params).Enumerate()
+	params.MyTasks = []int{1}
+	one := NewEnumerator(params).Enumerate()
+	params.MyTasks = []int{3}
+	three := NewEnumerator(params).Enumerate()
+	if several.NbMachineSeen != one.NbMachineSeen+three.NbMachineSeen {
+		t.Error(several.NbMachineSeen, one.NbMachineSeen, three.NbMachineSeen)
+	}
+}
+
+func TestEstimateTasks(t *testing.T) {
+	params := getSmallRunParameters(3, 4, BOUNDARY_STAY)
+	whole := NewEnumerator(params).Enumerate()
+
+	params.TaskDivisor = 4
+	params.TaskDepth = 3
+	estimates := EstimateTasks(params, 20, 0)
+
+	if !reflect.DeepEqual(estimates, EstimateTasks(params, 20, 0)) {
+		t.Error("estimates must be deterministic")
+	}
+
+	var total, share float64
+	for id, estimate := range estimates {
+		if estimate.ID != id || estimate.NbRoots == 0 {
+			t.Error(estimate)
+		}
+		total += estimate.EstimatedSize
+		share += estimate.EstimatedShare
+	}
+
+	if math.Abs(share-1) > 1e-9 {
+		t.Error(share)
+	}
+	// Knuth's estimator is unbiased but not exact
+	if math.Abs(total-float64(whole.NbMachineSeen)) > 0.2*float64(whole.NbMachineSeen) {
+		t.Error(total, whole.NbMachineSeen)
+	}
+}
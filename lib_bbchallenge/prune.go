@@ -1,54 +1,49 @@
 // Here we define filters that prune redundant TMs
 package bbchallenge
 
-func pruneTM(nbStates byte, tm TM, state byte, read byte) bool {
+func pruneTM(nbStates byte, nbSymbols byte, tm TM, state byte, read byte, boundary BoundaryMode) bool {
 	// Returns true if the machine should be ditched
-	return pruneEquivalentStates(nbStates, tm, state) ||
-		pruneRedundantTransition(nbStates, tm, state, read)
+	return pruneEquivalentStates(nbStates, nbSymbols, tm, state) ||
+		(boundary == BOUNDARY_WRAP && pruneRedundantTransition(nbStates, nbSymbols, tm, state, read))
 }
 
-func areStatesEquivalent(tm TM, state1 byte, state2 byte) bool {
-	// Returns if states 1 and 2 are equivalent, assuming they are fully defined
-	write1_0 := tm[6*state1+0]
-	move1_0 := tm[6*state1+1]
-	goto1_0 := tm[6*state1+2]
-
-	write1_1 := tm[6*state1+3]
-	move1_1 := tm[6*state1+4]
-	goto1_1 := tm[6*state1+5]
-
-	write2_0 := tm[6*state2+0]
-	move2_0 := tm[6*state2+1]
-	goto2_0 := tm[6*state2+2]
-
-	write2_1 := tm[6*state2+3]
-	move2_1 := tm[6*state2+4]
-	goto2_1 := tm[6*state2+5]
+func isStateFullyDefined(nbSymbols byte, tm TM, state byte) bool {
+	for read := byte(0); read < nbSymbols; read += 1 {
+		if tm[transitionIndex(nbSymbols, state, read)+2] == 0 {
+			return false
+		}
+	}
+	return true
+}
 
+func areStatesEquivalent(nbSymbols byte, tm TM, state1 byte, state2 byte) bool {
+	// Returns if states 1 and 2 are equivalent, assuming they are fully defined
 	minState := byte(MinI(int(state1+1), int(state2+1)))
 
-	if goto1_0 == state1+1 || goto1_0 == state2+1 {
-		goto1_0 = minState
-	}
+	for read := byte(0); read < nbSymbols; read += 1 {
+		i1 := transitionIndex(nbSymbols, state1+1, read)
+		i2 := transitionIndex(nbSymbols, state2+1, read)
 
-	if goto1_1 == state1+1 || goto1_1 == state2+1 {
-		goto1_1 = minState
-	}
+		goto1 := tm[i1+2]
+		goto2 := tm[i2+2]
 
-	if goto2_0 == state1+1 || goto2_0 == state2+1 {
-		goto2_0 = minState
-	}
+		if goto1 == state1+1 || goto1 == state2+1 {
+			goto1 = minState
+		}
 
-	if goto2_1 == state1+1 || goto2_1 == state2+1 {
-		goto2_1 = minState
+		if goto2 == state1+1 || goto2 == state2+1 {
+			goto2 = minState
+		}
+
+		if tm[i1] != tm[i2] || tm[i1+1] != tm[i2+1] || goto1 != goto2 {
+			return false
+		}
 	}
 
-	return write1_0 == write2_0 && write1_1 == write2_1 &&
-		move1_0 == move2_0 && move1_1 == move2_1 &&
-		goto1_0 == goto2_0 && goto1_1 == goto2_1
+	return true
 }
 
-func pruneEquivalentStates(nbStates byte, tm TM, state byte) bool {
+func pruneEquivalentStates(nbStates byte, nbSymbols byte, tm TM, state byte) bool {
 	// Quote from http://turbotm.de/~heiner/BB/mabu90.html#Enumeration:
 	// "If there are two states which are (syntactically) equivalent,
 	//  these two can be identified (Sigma(N+1) > Sigma(N)).
@@ -57,7 +52,7 @@ func pruneEquivalentStates(nbStates byte, tm TM, state byte) bool {
 	// Returns true if the machine should be ditched (i.e. no eq states)
 
 	i := state - 1
-	if tm[6*i+2] == 0 || tm[6*i+5] == 0 { // state not fully defined
+	if !isStateFullyDefined(nbSymbols, tm, i+1) {
 		return false
 	}
 
@@ -66,11 +61,11 @@ func pruneEquivalentStates(nbStates byte, tm TM, state byte) bool {
 			continue
 		}
 
-		if tm[6*j+2] == 0 || tm[6*j+5] == 0 { // state not fully defined
+		if !isStateFullyDefined(nbSymbols, tm, j+1) {
 			continue
 		}
 
-		if areStatesEquivalent(tm, i, j) {
+		if areStatesEquivalent(nbSymbols, tm, i, j) {
 			return true
 		}
 	}
@@ -78,31 +73,43 @@ func pruneEquivalentStates(nbStates byte, tm TM, state byte) bool {
 	return false
 }
 
-func pruneRedundantTransition(nbStates byte, tm TM, state byte, read byte) bool {
+func pruneRedundantTransition(nbStates byte, nbSymbols byte, tm TM, state byte, read byte) bool {
 	// Quote from http://turbotm.de/~heiner/BB/mabu90.html#Enumeration:
 	// "If a sequence of three transitions is guaranteed to have the same effect
 	// as a single transition, only one of both constructions need be inspected.
 	// Example: Let x,y,z and s be states with x!=y, a, b, and c arbitrary symbols,
 	//          and D from {L,R}, then (x,0)->(y,0,L), (x,1)->(y,1,L), and (y,b)->(z,c,D)
 	//          implies that (s,a)->(x,b,R) and (s,a)->(z,c,D) have the same effect."
+	//
+	// This only holds on circular tapes (BOUNDARY_WRAP), where moving right
+	// then left always comes back to the same cell. On bounded tapes the first
+	// transition may hit an edge, and the copying state then reads another
+	// cell or the machine halts or rejects: e.g. 1RB---_0LC1LC_---0RA halts
+	// with -boundary stay while 0RA---_0LC1LC_---0RA does not.
 
-	move := tm[6*(state-1)+3*read+1]
-	goto_ := tm[6*(state-1)+3*read+2]
+	move := tm[transitionIndex(nbSymbols, state, read)+1]
+	goto_ := tm[transitionIndex(nbSymbols, state, read)+2]
 
-	if tm[6*(goto_-1)+3*0+2] == 0 || tm[6*(goto_-1)+3*1+2] == 0 { // goto state is not fully defined
+	if !isStateFullyDefined(nbSymbols, tm, goto_) {
 		return false
 	}
 
-	if tm[6*(goto_-1)+3*0] != 0 || tm[6*(goto_-1)+3*1] != 1 { // goto state is not copying
-		return false
-	}
+	comingBackTo := tm[transitionIndex(nbSymbols, goto_, 0)+2]
 
-	if tm[6*(goto_-1)+3*0+1] != (1-move) || tm[6*(goto_-1)+3*1+1] != (1-move) { // goto state is not coming back
-		return false
-	}
+	for b := byte(0); b < nbSymbols; b += 1 {
+		i := transitionIndex(nbSymbols, goto_, b)
 
-	if tm[6*(goto_-1)+3*0+2] != tm[6*(goto_-1)+3*1+2] { // goto state is not coming back in same state
-		return false
+		if tm[i] != b { // goto state is not copying
+			return false
+		}
+
+		if tm[i+1] != (1 - move) { // goto state is not coming back
+			return false
+		}
+
+		if tm[i+2] != comingBackTo { // goto state is not coming back in same state
+			return false
+		}
 	}
 
 	return true
@@ -1,7 +1,10 @@
 // Here we test our TM's filters
 package bbchallenge
 
-import "testing"
+import (
+	"math"
+	"testing"
+)
 
 func TestPruneEquivalentStates(t *testing.T) {
 
@@ -33,19 +36,19 @@ func TestPruneEquivalentStates(t *testing.T) {
 		0, 0, 0, 0, 0, 0,
 		0, 0, 0, 0, 0, 0}
 
-	if !pruneEquivalentStates(5, tm1, 1) {
+	if !pruneEquivalentStates(5, 2, tm1, 1) {
 		t.Fail()
 	}
 
-	if !pruneEquivalentStates(5, tm2, 1) {
+	if !pruneEquivalentStates(5, 2, tm2, 1) {
 		t.Fail()
 	}
 
-	if !pruneEquivalentStates(5, tm3, 1) {
+	if !pruneEquivalentStates(5, 2, tm3, 1) {
 		t.Fail()
 	}
 
-	if pruneEquivalentStates(5, tm4, 1) {
+	if pruneEquivalentStates(5, 2, tm4, 1) {
 		t.Fail()
 	}
 }
@@ -73,15 +76,146 @@ func TestPruneRedundantTransitions(t *testing.T) {
 		0, 0, 0, 0, 0, 0,
 		0, 0, 0, 0, 0, 0}
 
-	if !pruneRedundantTransition(5, tm1, 1, 0) {
+	if !pruneRedundantTransition(5, 2, tm1, 1, 0) {
 		t.Fail()
 	}
 
-	if !pruneRedundantTransition(5, tm2, 1, 0) {
+	if !pruneRedundantTransition(5, 2, tm2, 1, 0) {
 		t.Fail()
 	}
 
-	if pruneRedundantTransition(5, tm3, 1, 0) {
+	if pruneRedundantTransition(5, 2, tm3, 1, 0) {
 		t.Fail()
 	}
 }
+
+func TestPruneThreeSymbols(t *testing.T) {
+
+	tm1 := TM{
+		1, R, 2, 2, L, 2, 0, R, 2, // These states are equivalent
+		1, R, 1, 2, L, 1, 0, R, 1} //
+
+	tm2 := TM{
+		1, R, 2, 2, L, 2, 0, R, 2, // These states are NOT equivalent
+		1, R, 1, 2, L, 1, 1, R, 1} //
+
+	if !pruneEquivalentStates(2, 3, tm1, 1) {
+		t.Fail()
+	}
+
+	if pruneEquivalentStates(2, 3, tm2, 1) {
+		t.Fail()
+	}
+
+	tm3 := TM{
+		1, R, 2, 0, 0, 0, 0, 0, 0,
+		0, L, 3, 1, L, 3, 2, L, 3, // B copies every symbol and comes back in C
+		0, 0, 0, 0, 0, 0, 0, 0, 0}
+
+	tm4 := TM{
+		1, R, 2, 0, 0, 0, 0, 0, 0,
+		0, L, 3, 1, L, 3, 1, L, 3, // B does not copy symbol 2
+		0, 0, 0, 0, 0, 0, 0, 0, 0}
+
+	if !pruneRedundantTransition(3, 3, tm3, 1, 0) {
+		t.Fail()
+	}
+
+	if pruneRedundantTransition(3, 3, tm4, 1, 0) {
+		t.Fail()
+	}
+}
+
+// Returns a machine equivalent to tm, which pruneTM ditched after the
+// transition of state reading read was defined, on which that rule no longer
+// applies: equivalent states are merged and redundant transitions are
+// replaced by their effect. Returns false if the transition is replaced by
+// itself, i.e. the machine loops forever on it.
+func unprunedEquivalent(nbStates byte, nbSymbols byte, tm TM, state byte, read byte) (TM, bool) {
+	if pruneEquivalentStates(nbStates, nbSymbols, tm, state) {
+		for other := byte(1); other <= nbStates; other += 1 {
+			if other == state || !isStateFullyDefined(nbSymbols, tm, other) || !areStatesEquivalent(nbSymbols, tm, state-1, other-1) {
+				continue
+			}
+
+			// The start state is kept
+			keep, drop := MinI(int(state), int(other)), MaxI(int(state), int(other))
+			for i := 2; i < TM_SIZE; i += 3 {
+				if int(tm[i]) == drop {
+					tm[i] = byte(keep)
+				}
+			}
+			for r := byte(0); r < nbSymbols; r += 1 {
+				i := transitionIndex(nbSymbols, byte(drop), r)
+				tm[i], tm[i+1], tm[i+2] = 0, 0, 0
+			}
+			return tm, true
+		}
+	}
+
+	i := transitionIndex(nbSymbols, state, read)
+	comingBackTo := tm[transitionIndex(nbSymbols, tm[i+2], 0)+2]
+	j := transitionIndex(nbSymbols, comingBackTo, tm[i])
+	if i == j {
+		return tm, false
+	}
+	tm[i], tm[i+1], tm[i+2] = tm[j], tm[j+1], tm[j+2]
+	return tm, true
+}
+
+// A pruned machine has an unpruned equivalent which halts if and only if it
+// halts, whatever the boundary mode
+func FuzzPruneTM(f *testing.F) {
+	f.Add(fuzzInput(TM{1, R, 2, 1, L, 3, 1, R, 2, 1, L, 3, 1, L, 1, 0, R, 1}, 3, 2), uint8(1), uint8(1), uint8(5), uint8(BOUNDARY_WRAP))
+	f.Add(fuzzInput(TM{1, R, 2, 1, L, 3, 0, L, 3, 1, L, 3, 1, R, 4, 1, L, 1, 1, R, 1}, 4, 2), uint8(0), uint8(0), uint8(6), uint8(BOUNDARY_STAY))
+	f.Add(fuzzInput(getBB5Winner(), 5, 2), uint8(4), uint8(0), uint8(13), uint8(BOUNDARY_HALT))
+	// Redundant transition bouncing on the left edge with BOUNDARY_STAY
+	f.Add(fuzzInput(TM{1, R, 2, 0, 0, 0, 0, L, 3, 1, L, 3, 0, 0, 0, 0, R, 1}, 3, 2), uint8(0), uint8(0), uint8(3), uint8(BOUNDARY_STAY))
+
+	f.Fuzz(func(t *testing.T, data []byte, s uint8, r uint8, limitSpace uint8, b uint8) {
+		tm, nbStates, nbSymbols := fuzzTM(data)
+		// Like the machines of the enumeration, halt on undefined transitions
+		for i := 2; i < TM_SIZE; i += 3 {
+			if tm[i] == H {
+				tm[i-2], tm[i-1], tm[i] = 0, 0, 0
+			}
+		}
+		state := s%nbStates + 1
+		read := r % nbSymbols
+		space := int(limitSpace)%12 + 1
+		boundary := BoundaryMode(b % byte(len(boundaryModeNames)))
+		if tm[transitionIndex(nbSymbols, state, read)+2] == 0 {
+			return
+		}
+
+		haltStatus, _, _, _, _, _, _ := simulateDetectCycles(tm, nbSymbols, math.MaxInt, space, boundary)
+
+		equivalent := tm
+		for n := 0; pruneTM(nbStates, nbSymbols, equivalent, state, read, boundary); n += 1 {
+			var ok bool
+			equivalent, ok = unprunedEquivalent(nbStates, nbSymbols, equivalent, state, read)
+			// Loops forever once it takes the transition, or a cycle of
+			// redundant transitions: it halts only if it never takes it
+			if !ok || n > int(nbStates)*int(nbSymbols) {
+				undefined := tm
+				i := transitionIndex(nbSymbols, state, read)
+				undefined[i], undefined[i+1], undefined[i+2] = 0, 0, 0
+				_, endState, endRead, _, _, _, _ := simulateDetectCycles(undefined, nbSymbols, math.MaxInt, space, boundary)
+
+				if haltStatus == HALT && endState == state && endRead == read {
+					t.Fatalf("halting machine looping on a redundant transition\n%s", tm.ToAsciiTable(nbStates, nbSymbols))
+				}
+				return
+			}
+			if equivalent[transitionIndex(nbSymbols, state, read)+2] == 0 {
+				break
+			}
+		}
+
+		equivalentStatus, _, _, _, _, _, _ := simulateDetectCycles(equivalent, nbSymbols, math.MaxInt, space, boundary)
+		if (haltStatus == HALT) != (equivalentStatus == HALT) {
+			t.Fatalf("pruned machine (%s)\n%s\nand its equivalent (%s)\n%s",
+				haltStatus, tm.ToAsciiTable(nbStates, nbSymbols), equivalentStatus, equivalent.ToAsciiTable(nbStates, nbSymbols))
+		}
+	})
+}
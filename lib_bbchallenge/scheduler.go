@@ -0,0 +1,207 @@
+// Here we schedule the enumeration on a bounded pool of workers stealing
+// tasks from each other
+package bbchallenge
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// A node of the enumeration tree whose children remain to be enumerated
+// Invariant: tm's transition (state, read) is not defined
+type task struct {
+	tm          TM
+	state       byte
+	read        byte
+	steps_count int
+	space_count int
+	sub         *subtree // Checkpointed subtree the task belongs to, if any
+	lengths     uint64   // Tape lengths of a sweep whose tree contains the task, see sweep.go
+}
+
+// Each worker pushes the tasks it creates at the back of its own deque and
+// pops them from there, which enumerates the tree depth first and keeps the
+// deques small. When its deque is empty, a worker steals from the front of
+// the deque of another worker, where the biggest subtrees are.
+type worker struct {
+	mutex sync.Mutex
+	deque []task
+
+	// Only written by the worker, the mutex lets Report read them during
+	// the enumeration
+	mutexMetrics  sync.Mutex
+	metrics       Metrics
+	nbStolenTasks int
+
+	// Last BB records seen by the worker, see logRecords
+	records recordView
+	// Same for each tape length of a sweep, see sweep.go
+	sweepRecords []recordView
+}
+
+// Halting time and space of the BB records holders
+type recordView struct {
+	steps int
+	space int
+}
+
+func (w *worker) push(t task) {
+	w.mutex.Lock()
+	w.deque = append(w.deque, t)
+	w.mutex.Unlock()
+}
+
+func (w *worker) pop() (t task, ok bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if len(w.deque) == 0 {
+		return t, false
+	}
+	t = w.deque[len(w.deque)-1]
+	w.deque = w.deque[:len(w.deque)-1]
+	return t, true
+}
+
+func (w *worker) steal() (t task, ok bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if len(w.deque) == 0 {
+		return t, false
+	}
+	t = w.deque[0]
+	w.deque = w.deque[1:]
+	return t, true
+}
+
+func (w *worker) addMetrics(metrics Metrics) {
+	w.mutexMetrics.Lock()
+	w.metrics.add(metrics)
+	w.mutexMetrics.Unlock()
+}
+
+type scheduler struct {
+	nbPendingTasks int64 // Pushed and not yet done, accessed atomically
+	nbIdleWorkers  int32 // Accessed atomically
+
+	workers []*worker
+
+	mutexIdle sync.Mutex
+	idle      *sync.Cond // Signaled when tasks are pushed or when all are done
+}
+
+func newScheduler(nbWorkers int) *scheduler {
+	s := &scheduler{}
+	s.idle = sync.NewCond(&s.mutexIdle)
+	for i := 0; i < nbWorkers; i += 1 {
+		s.workers = append(s.workers, &worker{})
+	}
+	return s
+}
+
+func (s *scheduler) push(w *worker, t task) {
+	atomic.AddInt64(&s.nbPendingTasks, 1)
+	if t.sub != nil {
+		atomic.AddInt64(&t.sub.nbPendingTasks, 1)
+	}
+
+	w.push(t)
+
+	if atomic.LoadInt32(&s.nbIdleWorkers) > 0 {
+		s.mutexIdle.Lock()
+		s.idle.Signal()
+		s.mutexIdle.Unlock()
+	}
+}
+
+// Steals a task from the other workers, starting from a random one so that
+// idle workers do not all compete for the same deque
+func (s *scheduler) steal(w *worker) (t task, ok bool) {
+	start := rand.Intn(len(s.workers))
+	for i := range s.workers {
+		victim := s.workers[(start+i)%len(s.workers)]
+		if victim == w {
+			continue
+		}
+		if t, ok = victim.steal(); ok {
+			w.mutexMetrics.Lock()
+			w.nbStolenTasks += 1
+			w.mutexMetrics.Unlock()
+			return t, true
+		}
+	}
+	return t, false
+}
+
+func (s *scheduler) hasTasks() bool {
+	for _, w := range s.workers {
+		w.mutex.Lock()
+		n := len(w.deque)
+		w.mutex.Unlock()
+		if n > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Blocks until some tasks may be available, returns false once all tasks
+// are done
+func (s *scheduler) waitForTasks() bool {
+	s.mutexIdle.Lock()
+	defer s.mutexIdle.Unlock()
+
+	atomic.AddInt32(&s.nbIdleWorkers, 1)
+	defer atomic.AddInt32(&s.nbIdleWorkers, -1)
+
+	// Tasks pushed before nbIdleWorkers was incremented did not signal
+	if s.hasTasks() {
+		return true
+	}
+	if atomic.LoadInt64(&s.nbPendingTasks) == 0 {
+		return false
+	}
+
+	s.idle.Wait()
+	return atomic.LoadInt64(&s.nbPendingTasks) != 0
+}
+
+// Returns true when t was the last task of its subtree
+func (s *scheduler) done(t task) bool {
+	if atomic.AddInt64(&s.nbPendingTasks, -1) == 0 {
+		s.mutexIdle.Lock()
+		s.idle.Broadcast()
+		s.mutexIdle.Unlock()
+	}
+
+	return t.sub != nil && atomic.AddInt64(&t.sub.nbPendingTasks, -1) == 0
+}
+
+func (e *Enumerator) runWorker(w *worker) {
+	for {
+		t, ok := w.pop()
+		if !ok {
+			t, ok = e.scheduler.steal(w)
+		}
+		if !ok {
+			if !e.scheduler.waitForTasks() {
+				return
+			}
+			continue
+		}
+
+		if e.sweep != nil {
+			e.enumerateSweep(w, t)
+		} else {
+			e.enumerate(w, t)
+		}
+
+		// Counted after its children were pushed, so that the subtree is only
+		// completed once all its tasks are done
+		if e.scheduler.done(t) {
+			e.completeSubtree(t.sub)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Here we test the scheduling of the enumeration on several workers
+package bbchallenge
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+// The number of workers must not change the outcome of the enumeration
+func TestWorkersAgree(t *testing.T) {
+	params := getSmallRunParameters(3, 4, BOUNDARY_STAY)
+
+	var expected Report
+	var expectedHalting []byte
+	for _, nbWorkers := range []int{1, 2, 8} {
+		var halting bytes.Buffer
+		e := NewEnumerator(params)
+		e.NbWorkers = nbWorkers
+		e.HaltingSink = LegacySink{&lockedWriter{w: &halting}}
+		report := e.Enumerate()
+
+		if report.NbWorkers != nbWorkers {
+			t.Error(nbWorkers, report.NbWorkers)
+		}
+
+		if nbWorkers == 1 {
+			expected = report
+			expectedHalting = halting.Bytes()
+			if expected.NbMachineSeen == 0 {
+				t.Fatal("no machine enumerated")
+			}
+			continue
+		}
+
+		if report.Metrics != expected.Metrics {
+			t.Error(nbWorkers, report.Metrics, expected.Metrics)
+		}
+		if halting.Len() != len(expectedHalting) {
+			t.Error(nbWorkers, halting.Len(), len(expectedHalting))
+		}
+	}
+}
+
+// Subtrees are only completed once all their tasks are done, whichever
+// worker runs them
+func TestWorkersCheckpoint(t *testing.T) {
+	params := getSmallRunParameters(3, 4, BOUNDARY_STAY)
+	params.CheckpointDepth = 3
+
+	var halting bytes.Buffer
+	e := NewEnumerator(params)
+	e.NbWorkers = 4
+	e.HaltingSink = LegacySink{&lockedWriter{w: &halting}}
+	e.CheckpointPath = filepath.Join(t.TempDir(), "checkpoint.json")
+	report := e.Enumerate()
+
+	checkpoint, err := LoadCheckpoint(e.CheckpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !checkpoint.Finished || len(checkpoint.Completed) == 0 {
+		t.Error(checkpoint.Finished, len(checkpoint.Completed))
+	}
+	if checkpoint.HaltingSize != int64(halting.Len()) ||
+		halting.Len() != LEGACY_TM_SIZE*report.NbHaltingMachines {
+		t.Error(checkpoint.HaltingSize, halting.Len(), report.NbHaltingMachines)
+	}
+	// Machines above the checkpoint depth are not part of any subtree
+	if checkpoint.Metrics.NbMachineSeen == 0 || checkpoint.Metrics.NbMachineSeen >= report.NbMachineSeen {
+		t.Error(checkpoint.Metrics.NbMachineSeen, report.NbMachineSeen)
+	}
+}
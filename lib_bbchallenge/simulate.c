@@ -1,22 +1,15 @@
 // Here we simulate TMs in C
 #include "simulate.h"
 
-#define MAX_MEMORY 40000
-#define MAX_STATES 5
+#include <stdlib.h>
 
-#define H 6
+#define H 255  // Same as in simulate.go
 #define R 0
 #define L 1
 
-#define BB4 107
-#define BB4_SPACE 16
-
-// conjecture
-#define BB5 47176870
-#define BB5_SPACE 12289
-
 #define RETURN(HALT_STATUS, STATE, READ, STEPS_COUNT, SPACE_COUNT) \
   {                                                                \
+    free(tape);                                                    \
     *ret_state = STATE;                                            \
     *ret_read = READ;                                              \
     *ret_steps_count = STEPS_COUNT;                                \
@@ -31,49 +24,53 @@ const BYTE NO_HALT = 1;
 const BYTE UNDECIDED_TIME = 2;
 const BYTE UNDECIDED_SPACE = 3;
 
+// Not a halt status, see simulate.h
+const BYTE OUT_OF_MEMORY = SIMULATE_OUT_OF_MEMORY;
+
+// Boundary modes, see boundary.go
+#define BOUNDARY_STAY 0
+#define BOUNDARY_HALT 1
+#define BOUNDARY_REJECT 2
+#define BOUNDARY_WRAP 3
+
+// Same semantics as the Go backend: the tape has exactly limit_space cells,
+// the head starts on the leftmost one and what happens when it tries to move
+// past an edge is given by the boundary mode. Machines running for more than
+// bbt_upper_bound steps are declared NO_HALT. The transition of state s
+// reading symbol r starts at byte 3 * ((s - 1) * nb_symbols + r) of tm.
 BYTE simulate(BYTE* tm,
-              int limit_time,
+              int nb_symbols,
+              long long limit_time,
               int limit_space,
+              long long bbt_upper_bound,
+              int boundary,
               BYTE* ret_state,
               BYTE* ret_read,
-              int* ret_steps_count,
+              long long* ret_steps_count,
               int* ret_space_count) {
-  BYTE tape[MAX_MEMORY] = {0};
+  BYTE* tape = calloc(limit_space, sizeof(BYTE));
+  if (tape == NULL) {
+    return OUT_OF_MEMORY;
+  }
 
   int max_pos = 0;
-  int min_pos = MAX_MEMORY - 1;
-  int curr_head = MAX_MEMORY / 2;
+  int min_pos = limit_space - 1;
+  int curr_head = 0;
 
   BYTE curr_state = 1;
-  int steps_count = 0;
-
-  BYTE state_seen[MAX_STATES] = {0};
-  BYTE nbStateSeen = 0;
+  long long steps_count = 0;
 
-  BYTE read;
+  BYTE read = 0;
 
   while (curr_state != H) {
-    if (!state_seen[curr_state - 1]) {
-      nbStateSeen += 1;
-    }
-    state_seen[curr_state - 1] = 1;
-
-    if (nbStateSeen <= 4 && steps_count > BB4) {
-      RETURN(NO_HALT, 0, 0, steps_count, max_pos - min_pos + 1)
-    }
-
-    if (nbStateSeen <= 4 && max_pos - min_pos + 1 > BB4_SPACE) {
+    if (steps_count > bbt_upper_bound) {
       RETURN(NO_HALT, 0, 0, steps_count, max_pos - min_pos + 1)
     }
 
-    if (nbStateSeen == 5 && steps_count > limit_time) {
+    if (steps_count > limit_time) {
       RETURN(UNDECIDED_TIME, 0, 0, steps_count, max_pos - min_pos + 1)
     }
 
-    if (nbStateSeen == 5 && max_pos - min_pos + 1 > limit_space) {
-      RETURN(UNDECIDED_SPACE, 0, 0, steps_count, max_pos - min_pos + 1)
-    }
-
     if (curr_head < min_pos) {
       min_pos = curr_head;
     }
@@ -84,7 +81,7 @@ BYTE simulate(BYTE* tm,
 
     read = tape[curr_head];
 
-    BYTE tm_transition = 6 * (curr_state - 1) + 3 * read;
+    int tm_transition = 3 * ((curr_state - 1) * nb_symbols + read);
     BYTE write = tm[tm_transition];
     BYTE move = tm[tm_transition + 1];
     BYTE next_state = tm[tm_transition + 2];
@@ -96,15 +93,19 @@ BYTE simulate(BYTE* tm,
 
     tape[curr_head] = write;
 
-    if (move == R) {
-      curr_head += 1;
-      if (curr_head == MAX_MEMORY) {
-        RETURN(UNDECIDED_SPACE, 0, 0, steps_count, max_pos - min_pos + 1)
-      }
-    } else {
-      curr_head -= 1;
-      if (curr_head == -1) {
-        RETURN(UNDECIDED_SPACE, 0, 0, steps_count, max_pos - min_pos + 1)
+    curr_head += move == R ? 1 : -1;
+
+    if (curr_head < 0 || curr_head >= limit_space) {
+      switch (boundary) {
+        case BOUNDARY_HALT:
+          RETURN(HALT, H, read, steps_count + 1, max_pos - min_pos + 1)
+        case BOUNDARY_REJECT:
+          RETURN(NO_HALT, 0, 0, steps_count + 1, max_pos - min_pos + 1)
+        case BOUNDARY_WRAP:
+          curr_head = (curr_head + limit_space) % limit_space;
+          break;
+        default:
+          curr_head = curr_head < 0 ? 0 : limit_space - 1;
       }
     }
 
@@ -113,4 +114,4 @@ BYTE simulate(BYTE* tm,
   }
 
   RETURN(HALT, H, read, steps_count, max_pos - min_pos + 1);
-}
\ No newline at end of file
+}
@@ -5,17 +5,19 @@ package bbchallenge
 // #include "simulate.h"
 import "C"
 import (
+	"bytes"
 	"fmt"
 	"strconv"
-
-	tabulate "github.com/rgeoghegan/tabulate"
 )
 
 // We currently work with machines that have at most MAX_STATES states
-const MAX_STATES = 5
+// and MAX_SYMBOLS symbols
+const MAX_STATES = 8
+const MAX_SYMBOLS = 4
 
-// Name of halting state
-const H = 6
+// Name of halting state, out of the range of states. The seed database
+// uses 6 instead, see EncodeLegacyTM.
+const H = 255
 
 const R = 0
 const L = 1
@@ -29,7 +31,14 @@ const (
 	UNDECIDED_SPACE
 )
 
-var BBtUpperBound int
+var haltStatusNames = []string{"HALT", "NO_HALT", "UNDECIDED_TIME", "UNDECIDED_SPACE"}
+
+func (h HaltStatus) String() string {
+	if int(h) < len(haltStatusNames) {
+		return haltStatusNames[h]
+	}
+	return "UNKNOWN"
+}
 
 func MaxI(a int, b int) int {
 	if a > b {
@@ -45,23 +54,34 @@ func MinI(a int, b int) int {
 	return b
 }
 
-// We are considering <= 5-state 2-symbol TMs
-// The TM:
+// We are considering <= 8-state <= 4-symbol TMs
+// The transition of state s (starting at 1) reading symbol r is made of the
+// 3 bytes starting at 3*((s-1)*nbSymbols + r): write, move and target state.
+// Hence the first 30 bytes of a <= 5-state 2-symbol TM are the same as in the
+// seed database, apart from the halting state.
+// The 2-symbol TM:
 //
 // +---+-----------+-----+
 // | - |     0     |  1  |
 // +---+-----------+-----+
-// | A | 1RB       | 1RH |
+// | A | 1RB       | 1RZ |
 // | B | 1LB       | 0RC |
 // | C | 1LC       | 1LA |
 // | D | undefined | 1RA |
 // +---+-----------+-----+
 //
 // Is encoded by the array:
-// 1, 0, 2, 1, 1, 6, 1, 1, 2, 0, 0, 3, 1, 1, 3  1, 1, 1, 0, 0, 0, 1, 0, 1
+// 1, 0, 2, 1, 1, H, 1, 1, 2, 0, 0, 3, 1, 1, 3  1, 1, 1, 0, 0, 0, 1, 0, 1
 // 1, R, B, 1, R, H, 1, L, B, 0, R, C, 1, L, C, 1, L, A, -, -, -, 1, R, A
 
-type TM [2 * MAX_STATES * 3]byte
+const TM_SIZE = MAX_STATES * MAX_SYMBOLS * 3
+
+type TM [TM_SIZE]byte
+
+// Index in a TM of the transition of `state` reading `read`
+func transitionIndex(nbSymbols byte, state byte, read byte) int {
+	return 3 * (int(state-1)*int(nbSymbols) + int(read))
+}
 
 func tmTransitionToStr(b1 byte, b2 byte, b3 byte) (toRet string) {
 
@@ -77,28 +97,42 @@ func tmTransitionToStr(b1 byte, b2 byte, b3 byte) (toRet string) {
 		toRet += "L"
 	}
 
-	toRet += string(rune(int('A') + int(b3) - 1))
+	// Letter H is taken by the 8th state
+	if b3 == H {
+		toRet += "Z"
+	} else {
+		toRet += string(rune(int('A') + int(b3) - 1))
+	}
 
 	return toRet
 }
 
-func (tm TM) ToAsciiTable(nbStates byte) (toRet string) {
+// One line representation of the machine, e.g. 1RB1LB_1LA1RZ for the BB2
+// champion, see notation.go
+func (tm TM) ToCompactString(nbStates byte, nbSymbols byte) string {
+	return tm.ToText(nbStates, nbSymbols, DEFAULT_HALT_SYMBOL)
+}
+
+func (tm TM) ToAsciiTable(nbStates byte, nbSymbols byte) (toRet string) {
 
 	var table [][]string
 
-	for i := byte(0); i < nbStates; i += 1 {
+	for state := byte(1); state <= nbStates; state += 1 {
 
-		table = append(table, []string{string(rune(int('A') + int(i))),
-			tmTransitionToStr(tm[6*i], tm[6*i+1], tm[6*i+2]),
-			tmTransitionToStr(tm[6*i+3], tm[6*i+4], tm[6*i+5])})
+		row := []string{string(rune(int('A') + int(state) - 1))}
+		for read := byte(0); read < nbSymbols; read += 1 {
+			i := transitionIndex(nbSymbols, state, read)
+			row = append(row, tmTransitionToStr(tm[i], tm[i+1], tm[i+2]))
+		}
+		table = append(table, row)
 	}
 
-	layout := &tabulate.Layout{Headers: []string{"-", "0", "1"}, Format: tabulate.SimpleFormat}
-	asText, _ := tabulate.Tabulate(
-		table, layout,
-	)
+	headers := []string{"-"}
+	for read := 0; read < int(nbSymbols); read += 1 {
+		headers = append(headers, strconv.Itoa(read))
+	}
 
-	return asText
+	return AsciiTable(table, headers)
 }
 
 // Simulates the input TM from blank input
@@ -109,7 +143,9 @@ func (tm TM) ToAsciiTable(nbStates byte) (toRet string) {
 // - read (byte): Read symbol of undetermined transition if reached
 // - steps count
 // - space count
-func simulate(tm TM, limitTime int, limitSpace int) (HaltStatus, byte, byte, int, int) {
+// Machines running for more than bbtUpperBound steps are declared NO_HALT.
+// What happens at the edges of the tape is given by the boundary mode.
+func simulate(tm TM, nbSymbols byte, limitTime int, limitSpace int, bbtUpperBound int, boundary BoundaryMode) (HaltStatus, byte, byte, int, int) {
 	var tape = make([]byte, limitSpace)
 
 	max_pos := 0
@@ -132,7 +168,7 @@ func simulate(tm TM, limitTime int, limitSpace int) (HaltStatus, byte, byte, int
 		}
 		state_seen[curr_state-1] = true
 
-		if steps_count > BBtUpperBound {
+		if steps_count > bbtUpperBound {
 			return NO_HALT, 0, 0, steps_count, max_pos - min_pos + 1
 		}
 		if steps_count > limitTime {
@@ -145,7 +181,7 @@ func simulate(tm TM, limitTime int, limitSpace int) (HaltStatus, byte, byte, int
 
 		read = tape[curr_head]
 
-		tm_transition := 6*(curr_state-1) + 3*read
+		tm_transition := transitionIndex(nbSymbols, curr_state, read)
 		write := tm[tm_transition]
 		move := tm[tm_transition+1]
 		next_state := tm[tm_transition+2]
@@ -158,11 +194,17 @@ func simulate(tm TM, limitTime int, limitSpace int) (HaltStatus, byte, byte, int
 
 		tape[curr_head] = write
 
-		// Prevent tape head from moving beyond the edges of the tape
-		if move == R && curr_head < limitSpace-1 {
-			curr_head += 1
-		} else if move == L && curr_head > 0 {
-			curr_head -= 1
+		var hitWall bool
+		curr_head, hitWall = moveHead(curr_head, move, limitSpace, boundary)
+
+		if hitWall && boundary == BOUNDARY_HALT {
+			return HALT, H, read,
+				steps_count + 1, max_pos - min_pos + 1
+		}
+
+		if hitWall && boundary == BOUNDARY_REJECT {
+			return NO_HALT, 0, 0,
+				steps_count + 1, max_pos - min_pos + 1
 		}
 
 		steps_count += 1
@@ -173,23 +215,212 @@ func simulate(tm TM, limitTime int, limitSpace int) (HaltStatus, byte, byte, int
 		steps_count, max_pos - min_pos + 1
 }
 
+// An LBA configuration: state, head position and tape contents.
+// Since the tape is bounded there are finitely many of them, which is
+// what makes cycle detection exact.
+type configuration struct {
+	nbSymbols byte
+	state     byte
+	head      int
+	tape      []byte
+}
+
+func newConfiguration(nbSymbols byte, limitSpace int) configuration {
+	return configuration{nbSymbols: nbSymbols, state: 1, head: 0, tape: make([]byte, limitSpace)}
+}
+
+func (c *configuration) equals(other *configuration) bool {
+	// State and head differ most of the time, check them before the tape
+	return c.state == other.state && c.head == other.head &&
+		bytes.Equal(c.tape, other.tape)
+}
+
+func (c *configuration) set(other *configuration) {
+	c.state = other.state
+	c.head = other.head
+	copy(c.tape, other.tape)
+}
+
+type stepResult byte
+
+const (
+	STEP_OK stepResult = iota
+	STEP_UNDEFINED
+	STEP_WALL_HALT
+	STEP_WALL_REJECT
+)
+
+// Performs one step of the LBA, with the same semantics as `simulate`.
+// Also returns the symbol read.
+func (c *configuration) step(tm TM, boundary BoundaryMode) (stepResult, byte) {
+	read := c.tape[c.head]
+
+	tm_transition := transitionIndex(c.nbSymbols, c.state, read)
+	write := tm[tm_transition]
+	move := tm[tm_transition+1]
+	next_state := tm[tm_transition+2]
+
+	if next_state == 0 {
+		return STEP_UNDEFINED, read
+	}
+
+	c.tape[c.head] = write
+
+	var hitWall bool
+	c.head, hitWall = moveHead(c.head, move, len(c.tape), boundary)
+
+	if hitWall && boundary == BOUNDARY_HALT {
+		return STEP_WALL_HALT, read
+	}
+
+	if hitWall && boundary == BOUNDARY_REJECT {
+		return STEP_WALL_REJECT, read
+	}
+
+	c.state = next_state
+	return STEP_OK, read
+}
+
+// One step of the LBA for callers that keep their own tape, such as deciders:
+// the machine in `state` reads `read` with its head on `head`, on a tape of
+// length limitSpace whose head stays put on the edges (BOUNDARY_STAY).
+// Returns the symbol to write, the next state (0 on an undefined transition,
+// the head then does not move) and the next head position.
+func LbaStep(tm TM, nbSymbols byte, limitSpace int, state byte, read byte, head int) (write byte, nextState byte, nextHead int) {
+	tm_transition := transitionIndex(nbSymbols, state, read)
+	write = tm[tm_transition]
+	nextState = tm[tm_transition+2]
+
+	if nextState == 0 {
+		return read, 0, head
+	}
+
+	nextHead, _ = moveHead(head, tm[tm_transition+1], limitSpace, BOUNDARY_STAY)
+	return write, nextState, nextHead
+}
+
+// Same as `simulate` but instead of running for bbtUpperBound steps
+// non-halting is decided as soon as a configuration repeats, using Brent's
+// cycle detection algorithm. Halting machines give the exact same results
+// as with `simulate`.
+// Returns the same values as `simulate`, followed by:
+// - preperiod: number of steps before entering the cycle (NO_HALT only)
+// - period: length of the cycle (NO_HALT only, 0 if the machine rejected on
+// a wall with BOUNDARY_REJECT)
+// For NO_HALT, steps count is preperiod + period and space count is
+// the space used by the whole orbit.
+func simulateDetectCycles(tm TM, nbSymbols byte, limitTime int, limitSpace int, boundary BoundaryMode) (HaltStatus, byte, byte, int, int, int, int) {
+	hare := newConfiguration(nbSymbols, limitSpace)
+	tortoise := newConfiguration(nbSymbols, limitSpace)
+
+	max_pos := 0
+	min_pos := limitSpace - 1
+
+	steps_count := 0
+
+	// Brent: the tortoise teleports to the hare every power of two steps
+	power := 1
+	period := 0
+
+	for {
+		if steps_count > limitTime {
+			return UNDECIDED_TIME, 0, 0, steps_count, max_pos - min_pos + 1, 0, 0
+		}
+
+		min_pos = MinI(min_pos, hare.head)
+		max_pos = MaxI(max_pos, hare.head)
+
+		state := hare.state
+		result, read := hare.step(tm, boundary)
+
+		switch result {
+		case STEP_UNDEFINED:
+			return HALT, state, read,
+				steps_count + 1, max_pos - min_pos + 1, 0, 0
+		case STEP_WALL_HALT:
+			return HALT, H, read,
+				steps_count + 1, max_pos - min_pos + 1, 0, 0
+		case STEP_WALL_REJECT:
+			return NO_HALT, 0, 0,
+				steps_count + 1, max_pos - min_pos + 1, 0, 0
+		}
+
+		steps_count += 1
+		period += 1
+
+		if hare.state == H {
+			return HALT, H, read,
+				steps_count, max_pos - min_pos + 1, 0, 0
+		}
+
+		if hare.equals(&tortoise) {
+			break
+		}
+
+		if period == power {
+			tortoise.set(&hare)
+			power *= 2
+			period = 0
+		}
+	}
+
+	// We know the period, find the preperiod: start the hare `period` steps
+	// ahead of the tortoise and advance both until they meet.
+	// The space count is measured on this pass, which visits every
+	// configuration of the orbit exactly once.
+	hare = newConfiguration(nbSymbols, limitSpace)
+	tortoise = newConfiguration(nbSymbols, limitSpace)
+
+	max_pos = 0
+	min_pos = limitSpace - 1
+
+	for i := 0; i < period; i += 1 {
+		min_pos = MinI(min_pos, hare.head)
+		max_pos = MaxI(max_pos, hare.head)
+		hare.step(tm, boundary)
+	}
+
+	preperiod := 0
+	for !hare.equals(&tortoise) {
+		min_pos = MinI(min_pos, hare.head)
+		max_pos = MaxI(max_pos, hare.head)
+		hare.step(tm, boundary)
+		tortoise.step(tm, boundary)
+		preperiod += 1
+	}
+
+	return NO_HALT, 0, 0, preperiod + period, max_pos - min_pos + 1,
+		preperiod, period
+}
+
+// Same as simulateDetectCycles, for deciders which only need the halt status
+// and the number of steps of machines, see Simulate for their configurations
+func SimulateDetectCycles(tm TM, nbSymbols byte, limitTime int, limitSpace int, boundary BoundaryMode) (HaltStatus, byte, byte, int, int, int, int) {
+	return simulateDetectCycles(tm, nbSymbols, limitTime, limitSpace, boundary)
+}
+
 // Wrapper for the C simulation code in order to have same API as Go code
-func simulate_C_wrapper(tm TM, limitTime int, limitSpace int) (HaltStatus, byte, byte, int, int) {
+func simulate_C_wrapper(tm TM, nbSymbols byte, limitTime int, limitSpace int, bbtUpperBound int, boundary BoundaryMode) (HaltStatus, byte, byte, int, int) {
 	end_state := C.uchar(0)
 	read := C.uchar(0)
-	steps_count := C.int(0)
+	steps_count := C.longlong(0)
 	space_count := C.int(0)
 
-	halt_status := C.simulate((*C.uchar)(&tm[0]), C.int(limitTime), C.int(limitSpace), &end_state, &read, &steps_count, &space_count)
+	halt_status := C.simulate((*C.uchar)(&tm[0]), C.int(nbSymbols), C.longlong(limitTime), C.int(limitSpace), C.longlong(bbtUpperBound),
+		C.int(boundary), &end_state, &read, &steps_count, &space_count)
+	if halt_status == C.SIMULATE_OUT_OF_MEMORY {
+		panic("C backend: cannot allocate a tape of " + strconv.Itoa(limitSpace) + " cells")
+	}
 
 	return HaltStatus(halt_status), byte(end_state), byte(read), int(steps_count), int(space_count)
 }
 
 // Useful for debugging
-func printTM(nbStates byte, tm TM) {
-	for i := 0; i < int(nbStates); i += 1 {
-		for j := 0; j <= 1; j += 1 {
-			fmt.Printf("%d%d%d ", tm[6*i+3*j], tm[6*i+3*j+1], tm[6*i+3*j+2])
+func printTM(nbStates byte, nbSymbols byte, tm TM) {
+	for state := byte(1); state <= nbStates; state += 1 {
+		for read := byte(0); read < nbSymbols; read += 1 {
+			i := transitionIndex(nbSymbols, state, read)
+			fmt.Printf("%d%d%d ", tm[i], tm[i+1], tm[i+2])
 		}
 		fmt.Print("\n")
 	}
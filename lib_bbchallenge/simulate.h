@@ -1,12 +1,19 @@
 #ifndef DEF_SIMULATE_H
 #define DEF_SIMULATE_H
 
+// Returned by simulate when the tape cannot be allocated, the outputs are
+// then left untouched
+#define SIMULATE_OUT_OF_MEMORY 255
+
 unsigned char simulate(unsigned char* tm,
-                       int limit_time,
+                       int nb_symbols,
+                       long long limit_time,
                        int limit_space,
-                       unsigned char* ret_state,  // output
-                       unsigned char* ret_read,   // output
-                       int* ret_steps_count,      // output
-                       int* ret_space_count);     // output
+                       long long bbt_upper_bound,
+                       int boundary,
+                       unsigned char* ret_state,    // output
+                       unsigned char* ret_read,     // output
+                       long long* ret_steps_count,  // output
+                       int* ret_space_count);       // output
 
-#endif
\ No newline at end of file
+#endif
@@ -2,6 +2,7 @@
 package bbchallenge
 
 import (
+	"math"
 	"testing"
 	"time"
 )
@@ -22,14 +23,14 @@ func getBB5Winner() TM {
 		1, R, 3, 1, R, 2,
 		1, R, 4, 0, L, 5,
 		1, L, 1, 1, L, 4,
-		1, R, 6, 0, L, 1}
+		1, R, H, 0, L, 1}
 
 }
 
 func TestTabulateTM(t *testing.T) {
 
 	bb5_winner := getBB5Winner()
-	t.Log("\n" + bb5_winner.ToAsciiTable(5))
+	t.Log("\n" + bb5_winner.ToAsciiTable(5, 2))
 
 	notFullyDefinedTM := TM{
 		1, R, 2, 1, L, 3,
@@ -38,29 +39,380 @@ func TestTabulateTM(t *testing.T) {
 		1, L, 1, 1, L, 4,
 		0, 0, 0, 0, 0, 0}
 
-	t.Log("\n" + notFullyDefinedTM.ToAsciiTable(5))
+	t.Log("\n" + notFullyDefinedTM.ToAsciiTable(5, 2))
+
+	t.Log("\n" + getThreeSymbolTM().ToAsciiTable(2, 3))
 }
 
-func TestBackendGo(t *testing.T) {
-	start := time.Now()
-	bb5_winner := getBB5Winner()
+func getBB2Winner() TM {
+	// +---+-----+-----+
+	// | - |  0  |  1  |
+	// +---+-----+-----+
+	// | A | 1RB | 1LB |
+	// | B | 1LA | 1RH |
+	// +---+-----+-----+
+
+	return TM{
+		1, R, 2, 1, L, 2,
+		1, L, 1, 1, R, H,
+		0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0}
+}
+
+type simulationCase struct {
+	name          string
+	tm            TM
+	limitTime     int
+	limitSpace    int
+	bbtUpperBound int
+	boundary      BoundaryMode
+
+	haltStatus HaltStatus
+	endState   byte
+	read       byte
+	stepsCount int
+	spaceCount int
+}
+
+// Hand-traced runs under the LBA model (head starts on the leftmost cell)
+func getSimulationCases() []simulationCase {
+	slammer := TM{
+		1, R, 1, 1, R, 1,
+		0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0}
+
+	undefined := TM{
+		1, R, 2, 0, 0, 0,
+		0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0}
+
+	// Moves left as its first step, then halts on B1 or B0
+	leftFirst := TM{
+		1, L, 2, 0, 0, 0,
+		0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0}
+
+	return []simulationCase{
+		// A0 -> B1 -> A1 (bonks the left wall) -> B1 -> H
+		{"bb2 winner", getBB2Winner(), 1000, 10, 1000, BOUNDARY_STAY, HALT, H, 1, 4, 2},
+		{"undefined transition", undefined, 1000, 10, 1000, BOUNDARY_STAY, HALT, 2, 0, 2, 2},
+		{"slammer upper bound", slammer, 1000, 4, 64, BOUNDARY_STAY, NO_HALT, 0, 0, 65, 4},
+		{"slammer time limit", slammer, 3, 4, 64, BOUNDARY_STAY, UNDECIDED_TIME, 0, 0, 4, 4},
+		{"tape of length 1", slammer, 1000, 1, 4, BOUNDARY_STAY, NO_HALT, 0, 0, 5, 1},
+
+		{"slammer halts on wall", slammer, 1000, 4, 64, BOUNDARY_HALT, HALT, H, 0, 4, 4},
+		{"slammer rejects on wall", slammer, 1000, 4, 64, BOUNDARY_REJECT, NO_HALT, 0, 0, 4, 4},
+		{"slammer on circular tape", slammer, 1000, 4, 64, BOUNDARY_WRAP, NO_HALT, 0, 0, 65, 4},
+
+		{"left first stays", leftFirst, 1000, 4, 64, BOUNDARY_STAY, HALT, 2, 1, 2, 1},
+		{"left first halts on wall", leftFirst, 1000, 4, 64, BOUNDARY_HALT, HALT, H, 0, 1, 1},
+		{"left first rejects on wall", leftFirst, 1000, 4, 64, BOUNDARY_REJECT, NO_HALT, 0, 0, 1, 1},
+		// Lands on the last cell, reads B0
+		{"left first on circular tape", leftFirst, 1000, 4, 64, BOUNDARY_WRAP, HALT, 2, 0, 2, 4},
+	}
+}
+
+func testBackend(t *testing.T, backend func(TM, byte, int, int, int, BoundaryMode) (HaltStatus, byte, byte, int, int)) {
+	for _, c := range getSimulationCases() {
+		halt_status, end_state, read, steps_count, space_count := backend(c.tm, 2, c.limitTime, c.limitSpace, c.bbtUpperBound, c.boundary)
 
-	halt_status, end_state, read, steps_count, space_count := simulate(bb5_winner, BB5, BB5_SPACE)
+		if halt_status != c.haltStatus || end_state != c.endState || read != c.read ||
+			steps_count != c.stepsCount || space_count != c.spaceCount {
+			t.Error(c.name, halt_status, end_state, read, steps_count, space_count)
+		}
+	}
+}
 
-	if halt_status != HALT || end_state != H || read != 0 || steps_count != BB5 || space_count != BB5_SPACE {
+// 2-state 3-symbol machine:
+// +---+-----+-----+-----+
+// | - |  0  |  1  |  2  |
+// +---+-----+-----+-----+
+// | A | 1RB | 2LA | ??? |
+// | B | 2LA | 2RB | 1RA |
+// +---+-----+-----+-----+
+func getThreeSymbolTM() TM {
+	return TM{
+		1, R, 2, 2, L, 1, 0, 0, 0,
+		2, L, 1, 2, R, 2, 1, R, 1}
+}
+
+func testBackendThreeSymbols(t *testing.T, backend func(TM, byte, int, int, int, BoundaryMode) (HaltStatus, byte, byte, int, int)) {
+	// A0 1RB, B0 2LA, A1 2LA (stays on the left wall), A2 undefined
+	halt_status, end_state, read, steps_count, space_count := backend(getThreeSymbolTM(), 3, 1000, 2, 1000, BOUNDARY_STAY)
+
+	if halt_status != HALT || end_state != 1 || read != 2 || steps_count != 4 || space_count != 2 {
 		t.Error(halt_status, end_state, read, steps_count, space_count)
 	}
+}
+
+func TestBackendsThreeSymbols(t *testing.T) {
+	testBackendThreeSymbols(t, simulate)
+	testBackendThreeSymbols(t, simulate_C_wrapper)
+}
+
+// 8-state machine walking right through all its states: A0 1RB, B0 1RC,
+// ..., H0 1RZ
+func getEightStateTM() TM {
+	var tm TM
+	for state := byte(1); state <= MAX_STATES; state += 1 {
+		i := transitionIndex(2, state, 0)
+		tm[i], tm[i+1], tm[i+2] = 1, R, state+1
+	}
+	tm[transitionIndex(2, MAX_STATES, 0)+2] = H
+	return tm
+}
+
+func TestBackendsEightStates(t *testing.T) {
+	for _, backend := range []func(TM, byte, int, int, int, BoundaryMode) (HaltStatus, byte, byte, int, int){simulate, simulate_C_wrapper} {
+		halt_status, end_state, read, steps_count, space_count := backend(getEightStateTM(), 2, 1000, 9, 1000, BOUNDARY_STAY)
 
+		if halt_status != HALT || end_state != H || read != 0 || steps_count != 8 || space_count != 8 {
+			t.Error(halt_status, end_state, read, steps_count, space_count)
+		}
+	}
+}
+
+func TestBackendGo(t *testing.T) {
+	start := time.Now()
+	testBackend(t, simulate)
 	t.Log(time.Since(start))
 }
 
 func TestBackendC(t *testing.T) {
 	start := time.Now()
-	bb5_winner := getBB5Winner()
-	halt_status, end_state, read, steps_count, space_count := simulate_C_wrapper(bb5_winner, BB5, BB5_SPACE)
+	testBackend(t, simulate_C_wrapper)
+	t.Log(time.Since(start))
+}
 
-	if halt_status != HALT || end_state != H || read != 0 || steps_count != BB5 || space_count != BB5_SPACE {
-		t.Error(halt_status, end_state, read, steps_count, space_count)
+// Walks the enumeration tree of e below parent, with the same target states
+// and pruning as the enumeration (see `children`), and checks that both
+// backends agree on every machine.
+func compareBackendsOnTree(t *testing.T, e *Enumerator, parent task) int {
+	children, extended := e.children(parent)
+
+	for _, child := range children {
+		goStatus, goState, goRead, goSteps, goSpace := simulate(child.tm, e.NbSymbols, e.LimitTime, e.LimitSpace, e.BBtUpperBound, e.Boundary)
+		cStatus, cState, cRead, cSteps, cSpace := simulate_C_wrapper(child.tm, e.NbSymbols, e.LimitTime, e.LimitSpace, e.BBtUpperBound, e.Boundary)
+
+		if goStatus != cStatus || goState != cState || goRead != cRead || goSteps != cSteps || goSpace != cSpace {
+			t.Fatalf("backends disagree on tape length %d (%s)\n%s\ngo: %d %d %d %d %d\nc:  %d %d %d %d %d",
+				e.LimitSpace, e.Boundary, child.tm.ToAsciiTable(e.NbStates, e.NbSymbols),
+				goStatus, goState, goRead, goSteps, goSpace,
+				cStatus, cState, cRead, cSteps, cSpace)
+		}
+	}
+
+	nbMachines := len(children)
+	for _, child := range extended {
+		nbMachines += compareBackendsOnTree(t, e, child)
+	}
+	return nbMachines
+}
+
+// Enumerator whose time limit is the upper bound, as in the enumeration
+func backendsEnumerator(nbStates byte, nbSymbols byte, limitSpace int, boundary BoundaryMode) *Enumerator {
+	params := getSmallRunParameters(nbStates, limitSpace, boundary)
+	params.NbSymbols = nbSymbols
+	params.LimitTime = UpperBound(nbStates, nbSymbols, limitSpace)
+	return NewEnumerator(params)
+}
+
+func TestBackendsAgree(t *testing.T) {
+	for boundary := BOUNDARY_STAY; boundary <= BOUNDARY_WRAP; boundary += 1 {
+		for nbStates := byte(2); nbStates <= 4; nbStates += 1 {
+			for _, limitSpace := range []int{1, 2, 3} {
+				// The other boundary modes are only checked on a smaller
+				// set of 4-state trees to keep the test fast
+				if nbStates == 4 && boundary != BOUNDARY_STAY && limitSpace != 2 {
+					continue
+				}
+
+				e := backendsEnumerator(nbStates, 2, limitSpace, boundary)
+				nbMachines := compareBackendsOnTree(t, e, task{state: 1})
+				t.Logf("%d states, tape length %d (%s): %d machines", nbStates, limitSpace, boundary, nbMachines)
+			}
+		}
+	}
+}
+
+// 2-state 4-symbol trees already have millions of machines
+func TestBackendsAgreeThreeSymbols(t *testing.T) {
+	for boundary := BOUNDARY_STAY; boundary <= BOUNDARY_WRAP; boundary += 1 {
+		for _, limitSpace := range []int{1, 2, 3} {
+			e := backendsEnumerator(2, 3, limitSpace, boundary)
+			nbMachines := compareBackendsOnTree(t, e, task{state: 1})
+			t.Logf("2 states 3 symbols, tape length %d (%s): %d machines", limitSpace, boundary, nbMachines)
+		}
+	}
+}
+
+// Builds a machine from fuzzing input: the first byte gives the number of
+// states and symbols, the next ones the transitions. Targets beyond the
+// number of states stand for the halting state.
+func fuzzTM(data []byte) (tm TM, nbStates byte, nbSymbols byte) {
+	if len(data) == 0 {
+		return tm, 1, 2
+	}
+	nbStates = data[0]%MAX_STATES + 1
+	nbSymbols = (data[0]/MAX_STATES)%(MAX_SYMBOLS-1) + 2
+	data = data[1:]
+
+	for i := 0; i < 3*int(nbStates)*int(nbSymbols) && i+2 < len(data); i += 3 {
+		tm[i] = data[i] % nbSymbols
+		tm[i+1] = data[i+1] % 2
+		tm[i+2] = data[i+2] % (nbStates + 2)
+		if tm[i+2] == nbStates+1 {
+			tm[i+2] = H
+		}
+	}
+	return tm, nbStates, nbSymbols
+}
+
+// Encodes a machine as fuzzing input, for the seed corpus
+func fuzzInput(tm TM, nbStates byte, nbSymbols byte) []byte {
+	data := []byte{(nbSymbols-2)*MAX_STATES + nbStates - 1}
+	for i := 0; i < 3*int(nbStates)*int(nbSymbols); i += 3 {
+		goTo := tm[i+2]
+		if goTo == H {
+			goTo = nbStates + 1
+		}
+		data = append(data, tm[i], tm[i+1], goTo)
+	}
+	return data
+}
+
+func FuzzBackendsAgree(f *testing.F) {
+	f.Add(fuzzInput(getBB5Winner(), 5, 2), uint16(100), uint16(1000), uint8(13), uint8(BOUNDARY_STAY))
+	f.Add(fuzzInput(getBB2Winner(), 2, 2), uint16(100), uint16(1000), uint8(3), uint8(BOUNDARY_REJECT))
+	f.Add(fuzzInput(getThreeSymbolTM(), 2, 3), uint16(5), uint16(1000), uint8(2), uint8(BOUNDARY_WRAP))
+	f.Add(fuzzInput(getEightStateTM(), 8, 2), uint16(1000), uint16(20), uint8(9), uint8(BOUNDARY_HALT))
+
+	f.Fuzz(func(t *testing.T, data []byte, limitTime uint16, bbtUpperBound uint16, limitSpace uint8, boundary uint8) {
+		tm, nbStates, nbSymbols := fuzzTM(data)
+		space := int(limitSpace)%32 + 1
+		mode := BoundaryMode(boundary % byte(len(boundaryModeNames)))
+
+		goStatus, goState, goRead, goSteps, goSpace := simulate(tm, nbSymbols, int(limitTime), space, int(bbtUpperBound), mode)
+		cStatus, cState, cRead, cSteps, cSpace := simulate_C_wrapper(tm, nbSymbols, int(limitTime), space, int(bbtUpperBound), mode)
+
+		if goStatus != cStatus || goState != cState || goRead != cRead || goSteps != cSteps || goSpace != cSpace {
+			t.Fatalf("backends disagree on tape length %d (%s), time limit %d, upper bound %d\n%s\ngo: %d %d %d %d %d\nc:  %d %d %d %d %d",
+				space, mode, limitTime, bbtUpperBound, tm.ToAsciiTable(nbStates, nbSymbols),
+				goStatus, goState, goRead, goSteps, goSpace,
+				cStatus, cState, cRead, cSteps, cSpace)
+		}
+	})
+}
+
+// Runs the LBA for `steps` steps and returns the configuration reached
+func configurationAt(tm TM, nbSymbols byte, limitSpace int, boundary BoundaryMode, steps int) configuration {
+	c := newConfiguration(nbSymbols, limitSpace)
+	for i := 0; i < steps; i += 1 {
+		c.step(tm, boundary)
+	}
+	return c
+}
+
+func TestDetectCyclesSlammer(t *testing.T) {
+	// Writes 1s until it hits the right wall, then stays there forever
+	tm := TM{
+		1, R, 1, 1, R, 1,
+		0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0}
+
+	halt_status, _, _, steps_count, space_count, preperiod, period := simulateDetectCycles(tm, 2, 1000, 4, BOUNDARY_STAY)
+
+	if halt_status != NO_HALT || steps_count != 5 || space_count != 4 || preperiod != 4 || period != 1 {
+		t.Error(halt_status, steps_count, space_count, preperiod, period)
+	}
+}
+
+// Enumerates every 2-state machine (undefined transitions included) and checks
+// that cycle detection agrees with the upper bound simulation, and that the
+// reported preperiod and period are the minimal ones.
+func TestDetectCyclesAgreesWithUpperBound(t *testing.T) {
+	limitSpace := 5
+
+	var transitions [][3]byte
+	transitions = append(transitions, [3]byte{0, 0, 0})
+	for write := byte(0); write <= 1; write += 1 {
+		for move := byte(0); move <= 1; move += 1 {
+			for target := byte(1); target <= 2; target += 1 {
+				transitions = append(transitions, [3]byte{write, move, target})
+			}
+		}
+	}
+
+	for boundary := BOUNDARY_STAY; boundary <= BOUNDARY_WRAP; boundary += 1 {
+		bbtUpperBound := UpperBound(2, 2, limitSpace)
+
+		for _, t0 := range transitions {
+			for _, t1 := range transitions {
+				for _, t2 := range transitions {
+					for _, t3 := range transitions {
+						var tm TM
+						copy(tm[0:3], t0[:])
+						copy(tm[3:6], t1[:])
+						copy(tm[6:9], t2[:])
+						copy(tm[9:12], t3[:])
+
+						checkDetectCycles(t, tm, limitSpace, bbtUpperBound, boundary)
+					}
+				}
+			}
+		}
+	}
+}
+
+func checkDetectCycles(t *testing.T, tm TM, limitSpace int, bbtUpperBound int, boundary BoundaryMode) {
+	status, state, read, steps, space := simulate(tm, 2, bbtUpperBound, limitSpace, bbtUpperBound, boundary)
+	// Brent's algorithm may need more steps than the upper bound before
+	// noticing the cycle, so it gets no time limit
+	cStatus, cState, cRead, cSteps, cSpace, preperiod, period := simulateDetectCycles(tm, 2, math.MaxInt, limitSpace, boundary)
+
+	if status != cStatus {
+		t.Fatal(boundary, tm.ToAsciiTable(2, 2), status, cStatus)
+	}
+
+	// Halted, or rejected on a wall
+	if status == HALT || period == 0 {
+		if state != cState || read != cRead || steps != cSteps || space != cSpace {
+			t.Fatal(boundary, tm.ToAsciiTable(2, 2), state, read, steps, space, cState, cRead, cSteps, cSpace)
+		}
+		return
+	}
+
+	if cSteps != preperiod+period {
+		t.Fatal(boundary, tm.ToAsciiTable(2, 2), cSteps, preperiod, period)
+	}
+
+	start := configurationAt(tm, 2, limitSpace, boundary, preperiod)
+	end := configurationAt(tm, 2, limitSpace, boundary, preperiod+period)
+	if !start.equals(&end) {
+		t.Fatal(boundary, tm.ToAsciiTable(2, 2), "not a cycle", preperiod, period)
+	}
+
+	if preperiod > 0 {
+		before := configurationAt(tm, 2, limitSpace, boundary, preperiod-1)
+		beforeEnd := configurationAt(tm, 2, limitSpace, boundary, preperiod-1+period)
+		if before.equals(&beforeEnd) {
+			t.Fatal(boundary, tm.ToAsciiTable(2, 2), "preperiod not minimal", preperiod, period)
+		}
+	}
+
+	for p := 1; p < period; p += 1 {
+		other := configurationAt(tm, 2, limitSpace, boundary, preperiod+p)
+		if start.equals(&other) {
+			t.Fatal(boundary, tm.ToAsciiTable(2, 2), "period not minimal", preperiod, period)
+		}
 	}
-	t.Log(time.Since(start))
 }
@@ -0,0 +1,309 @@
+// Here we define where and how the results of the enumeration are written
+package bbchallenge
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Everything we know about a simulated machine
+type Record struct {
+	TM         TM
+	NbStates   byte
+	NbSymbols  byte
+	HaltStatus HaltStatus
+	EndState   byte // State of the undefined transition reached, H if the machine halted on a wall
+	Read       byte // Read symbol of the undefined transition reached
+	StepsCount int
+	SpaceCount int
+	TapeLength int
+}
+
+// Receives the records of the enumeration. Write returns the number of bytes
+// it appended to its output, they are recorded in checkpoints. It may be
+// called from several go routines at the same time.
+type ResultSink interface {
+	Write(record Record) (int, error)
+}
+
+// Commits the output of the sink to stable storage when it is a file, so
+// that the sizes recorded in checkpoints are not ahead of the files
+func SyncSink(sink ResultSink) error {
+	var w io.Writer
+	switch s := sink.(type) {
+	case LegacySink:
+		w = s.W
+	case ExtendedSink:
+		w = s.W
+	case *CSVSink:
+		w = s.w
+	case JSONLinesSink:
+		w = s.W
+	}
+
+	if syncer, ok := w.(interface{ Sync() error }); ok {
+		return syncer.Sync()
+	}
+	return nil
+}
+
+type RecordFormat byte
+
+const (
+	// The 30-byte TM alone, as in the seed database (<= 5-state 2-symbol
+	// machines only)
+	FORMAT_LEGACY RecordFormat = iota
+	// The version, the TM and the rest of the record, see ExtendedSink
+	FORMAT_EXTENDED
+	FORMAT_CSV
+	// One JSON object per line
+	FORMAT_JSONL
+)
+
+var recordFormatNames = []string{"legacy", "extended", "csv", "jsonl"}
+
+func (f RecordFormat) String() string {
+	if int(f) < len(recordFormatNames) {
+		return recordFormatNames[f]
+	}
+	return "unknown"
+}
+
+func ParseRecordFormat(name string) (RecordFormat, error) {
+	for i, formatName := range recordFormatNames {
+		if name == formatName {
+			return RecordFormat(i), nil
+		}
+	}
+	return FORMAT_LEGACY, errors.New("unknown record format '" + name +
+		"', must be one of: " + strings.Join(recordFormatNames, ", "))
+}
+
+// Record formats are saved by name in checkpoints
+func (f RecordFormat) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+func (f *RecordFormat) UnmarshalText(text []byte) (err error) {
+	*f, err = ParseRecordFormat(string(text))
+	return err
+}
+
+// Returns a sink writing records to w in the given format. When withHeader
+// is set, formats that have a header (CSV) write it before the first record.
+func NewResultSink(format RecordFormat, w io.Writer, withHeader bool) ResultSink {
+	switch format {
+	case FORMAT_EXTENDED:
+		return ExtendedSink{w}
+	case FORMAT_CSV:
+		return &CSVSink{w: w, headerWritten: !withHeader}
+	case FORMAT_JSONL:
+		return JSONLinesSink{w}
+	}
+	return LegacySink{w}
+}
+
+// Discards all records
+type DiscardSink struct{}
+
+func (DiscardSink) Write(record Record) (int, error) {
+	return 0, nil
+}
+
+// Size of a TM in the seed database
+const LEGACY_TM_SIZE = 30
+
+// Halting state in the seed database
+const LEGACY_H = 6
+
+// Encodes a <= 5-state 2-symbol TM as in the seed database
+func EncodeLegacyTM(nbStates byte, nbSymbols byte, tm TM) (encoded [LEGACY_TM_SIZE]byte, err error) {
+	if nbStates > 5 || nbSymbols != 2 {
+		return encoded, errors.New("the legacy format only supports <= 5-state 2-symbol machines")
+	}
+
+	copy(encoded[:], tm[:LEGACY_TM_SIZE])
+	for i := 2; i < LEGACY_TM_SIZE; i += 3 {
+		if encoded[i] == H {
+			encoded[i] = LEGACY_H
+		}
+	}
+	return encoded, nil
+}
+
+// Decodes a TM of the seed database
+func DecodeLegacyTM(buffer []byte) (tm TM, err error) {
+	if len(buffer) != LEGACY_TM_SIZE {
+		return tm, errors.New("invalid legacy TM size")
+	}
+
+	copy(tm[:], buffer)
+	for i := 2; i < LEGACY_TM_SIZE; i += 3 {
+		if tm[i] == LEGACY_H {
+			tm[i] = H
+		}
+	}
+	return tm, nil
+}
+
+// Whether buffer is a machine in the legacy format: its symbols and moves are
+// 0 or 1 and its states at most LEGACY_H
+func isLegacyTM(buffer []byte) bool {
+	if len(buffer) != LEGACY_TM_SIZE {
+		return false
+	}
+	for i := 0; i < LEGACY_TM_SIZE; i += 3 {
+		if buffer[i] > 1 || buffer[i+1] > 1 || buffer[i+2] > LEGACY_H {
+			return false
+		}
+	}
+	return true
+}
+
+type LegacySink struct {
+	W io.Writer
+}
+
+func (s LegacySink) Write(record Record) (int, error) {
+	encoded, err := EncodeLegacyTM(record.NbStates, record.NbSymbols, record.TM)
+	if err != nil {
+		return 0, err
+	}
+	return s.W.Write(encoded[:])
+}
+
+// Version of the extended format, written at the beginning of each record.
+// Any change to the layout below must come with a new version.
+const EXTENDED_FORMAT_VERSION = 1
+
+// Room for the TM in extended records: 8 states, 4 symbols
+const EXTENDED_TM_SIZE = 8 * 4 * 3
+
+// Size of a record in the extended format
+const EXTENDED_RECORD_SIZE = 1 + EXTENDED_TM_SIZE + 21
+
+// Extended records are made of EXTENDED_RECORD_SIZE bytes:
+//   - 1 byte: EXTENDED_FORMAT_VERSION
+//   - EXTENDED_TM_SIZE bytes: the TM, padded with zeros
+//   - 1 byte each: nbStates, nbSymbols, halt status, end state and read symbol
+//   - 8 bytes: steps count
+//   - 4 bytes: space count
+//   - 4 bytes: tape length
+//
+// Integers are big-endian, as in the database header.
+type ExtendedSink struct {
+	W io.Writer
+}
+
+func EncodeExtendedRecord(record Record) []byte {
+	var buffer [EXTENDED_RECORD_SIZE]byte
+	buffer[0] = EXTENDED_FORMAT_VERSION
+	copy(buffer[1:1+EXTENDED_TM_SIZE], record.TM[:])
+	fields := buffer[1+EXTENDED_TM_SIZE:]
+	fields[0] = record.NbStates
+	fields[1] = record.NbSymbols
+	fields[2] = byte(record.HaltStatus)
+	fields[3] = record.EndState
+	fields[4] = record.Read
+	binary.BigEndian.PutUint64(fields[5:13], uint64(record.StepsCount))
+	binary.BigEndian.PutUint32(fields[13:17], uint32(record.SpaceCount))
+	binary.BigEndian.PutUint32(fields[17:21], uint32(record.TapeLength))
+	return buffer[:]
+}
+
+func DecodeExtendedRecord(buffer []byte) (record Record, err error) {
+	if len(buffer) != EXTENDED_RECORD_SIZE {
+		return record, errors.New("invalid extended record size")
+	}
+
+	if buffer[0] != EXTENDED_FORMAT_VERSION {
+		return record, fmt.Errorf("unsupported extended record version %d", buffer[0])
+	}
+
+	copy(record.TM[:], buffer[1:1+EXTENDED_TM_SIZE])
+	fields := buffer[1+EXTENDED_TM_SIZE:]
+	record.NbStates = fields[0]
+	record.NbSymbols = fields[1]
+	record.HaltStatus = HaltStatus(fields[2])
+	record.EndState = fields[3]
+	record.Read = fields[4]
+	record.StepsCount = int(binary.BigEndian.Uint64(fields[5:13]))
+	record.SpaceCount = int(binary.BigEndian.Uint32(fields[13:17]))
+	record.TapeLength = int(binary.BigEndian.Uint32(fields[17:21]))
+	return record, nil
+}
+
+func (s ExtendedSink) Write(record Record) (int, error) {
+	return s.W.Write(EncodeExtendedRecord(record))
+}
+
+// In text formats, only the transitions of the nbStates states are written,
+// hex encoded
+func (record Record) hexTM() string {
+	return hex.EncodeToString(record.TM[:3*int(record.NbStates)*int(record.NbSymbols)])
+}
+
+type CSVSink struct {
+	mutex         sync.Mutex
+	w             io.Writer
+	headerWritten bool
+}
+
+const csvHeader = "tm,nb_states,nb_symbols,halt_status,end_state,read,steps,space,tape_length\n"
+
+func (s *CSVSink) Write(record Record) (int, error) {
+	line := fmt.Sprintf("%s,%d,%d,%s,%d,%d,%d,%d,%d\n",
+		record.hexTM(), record.NbStates, record.NbSymbols, record.HaltStatus,
+		record.EndState, record.Read, record.StepsCount, record.SpaceCount, record.TapeLength)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.headerWritten {
+		s.headerWritten = true
+		line = csvHeader + line
+	}
+
+	return io.WriteString(s.w, line)
+}
+
+type JSONLinesSink struct {
+	W io.Writer
+}
+
+type jsonRecord struct {
+	TM         string `json:"tm"`
+	NbStates   byte   `json:"nb_states"`
+	NbSymbols  byte   `json:"nb_symbols"`
+	HaltStatus string `json:"halt_status"`
+	EndState   byte   `json:"end_state"`
+	Read       byte   `json:"read"`
+	StepsCount int    `json:"steps"`
+	SpaceCount int    `json:"space"`
+	TapeLength int    `json:"tape_length"`
+}
+
+func (s JSONLinesSink) Write(record Record) (int, error) {
+	asJson, err := json.Marshal(jsonRecord{
+		TM:         record.hexTM(),
+		NbStates:   record.NbStates,
+		NbSymbols:  record.NbSymbols,
+		HaltStatus: record.HaltStatus.String(),
+		EndState:   record.EndState,
+		Read:       record.Read,
+		StepsCount: record.StepsCount,
+		SpaceCount: record.SpaceCount,
+		TapeLength: record.TapeLength,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return s.W.Write(append(asJson, '\n'))
+}
@@ -0,0 +1,142 @@
+// Here we test the result sinks
+package bbchallenge
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func getBB2Record() Record {
+	return Record{
+		TM:         getBB2Winner(),
+		NbStates:   2,
+		NbSymbols:  2,
+		HaltStatus: HALT,
+		EndState:   H,
+		Read:       0,
+		StepsCount: 6,
+		SpaceCount: 4,
+		TapeLength: 10,
+	}
+}
+
+func TestExtendedRecord(t *testing.T) {
+	record := getBB2Record()
+	record.StepsCount = 1 << 40
+
+	var buffer bytes.Buffer
+	n, err := ExtendedSink{&buffer}.Write(record)
+	if err != nil || n != EXTENDED_RECORD_SIZE {
+		t.Fatal(n, err)
+	}
+
+	if buffer.Bytes()[0] != EXTENDED_FORMAT_VERSION || !bytes.Equal(buffer.Bytes()[1:1+TM_SIZE], record.TM[:]) {
+		t.Error("the extended format must start with its version and the TM")
+	}
+
+	decoded, err := DecodeExtendedRecord(buffer.Bytes())
+	if err != nil || decoded != record {
+		t.Error(decoded, err)
+	}
+}
+
+// Legacy records are the first 30 bytes of 2-symbol TMs, with 6 as
+// halting state
+func TestLegacySink(t *testing.T) {
+	record := getBB2Record()
+
+	var buffer bytes.Buffer
+	n, err := LegacySink{&buffer}.Write(record)
+	if err != nil || n != LEGACY_TM_SIZE {
+		t.Fatal(n, err)
+	}
+
+	expected := []byte{
+		1, R, 2, 1, L, 2,
+		1, L, 1, 1, R, 6,
+		0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0}
+	if !bytes.Equal(buffer.Bytes(), expected) {
+		t.Error(buffer.Bytes())
+	}
+
+	decoded, err := DecodeLegacyTM(buffer.Bytes())
+	if err != nil || decoded != record.TM {
+		t.Error(decoded, err)
+	}
+
+	record.NbStates = 6
+	if _, err := (LegacySink{&buffer}).Write(record); err == nil {
+		t.Error("6-state machines cannot be written in the legacy format")
+	}
+}
+
+func TestCSVSink(t *testing.T) {
+	var buffer bytes.Buffer
+	sink := NewResultSink(FORMAT_CSV, &buffer, true)
+
+	total := 0
+	for i := 0; i < 2; i += 1 {
+		n, err := sink.Write(getBB2Record())
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += n
+	}
+
+	if total != buffer.Len() {
+		t.Error(total, buffer.Len())
+	}
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 3 || lines[0]+"\n" != csvHeader {
+		t.Fatal(lines)
+	}
+	tm := getBB2Winner()
+	if lines[1] != hex.EncodeToString(tm[:12])+",2,2,HALT,255,0,6,4,10" {
+		t.Error(lines[1])
+	}
+
+	buffer.Reset()
+	NewResultSink(FORMAT_CSV, &buffer, false).Write(getBB2Record())
+	if strings.Count(buffer.String(), "\n") != 1 {
+		t.Error("unexpected header", buffer.String())
+	}
+}
+
+func TestJSONLinesSink(t *testing.T) {
+	var buffer bytes.Buffer
+	sink := NewResultSink(FORMAT_JSONL, &buffer, true)
+	sink.Write(getBB2Record())
+	sink.Write(getBB2Record())
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatal(lines)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["halt_status"] != "HALT" || decoded["steps"] != float64(6) || decoded["tape_length"] != float64(10) {
+		t.Error(decoded)
+	}
+}
+
+func TestParseRecordFormat(t *testing.T) {
+	for format := FORMAT_LEGACY; format <= FORMAT_JSONL; format += 1 {
+		parsed, err := ParseRecordFormat(format.String())
+		if err != nil || parsed != format {
+			t.Error(format, parsed, err)
+		}
+	}
+
+	if _, err := ParseRecordFormat("xml"); err == nil {
+		t.Error("xml is not a record format")
+	}
+}
@@ -0,0 +1,319 @@
+// Here we compute LBA busy beaver values for a range of tape lengths
+package bbchallenge
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Outcome of the enumeration for one tape length
+type SweepRow struct {
+	TapeLength int
+	Report
+}
+
+// Number of tape lengths whose trees are enumerated together, see task.lengths
+const SWEEP_MAX_TAPE_LENGTHS = 64
+
+// A sweep walks the enumeration trees of several tape lengths at once: they
+// share their nodes down to the machines whose run depends on the tape
+// length. A run which never reaches the last cell of a tape is the same on
+// any longer tape (see simulateSweep), so that such machines are simulated
+// once for all the longer tapes.
+type sweep struct {
+	enumerators []*Enumerator // One per tape length, in increasing order
+
+	mutex   sync.Mutex
+	metrics []Metrics // Metrics of each tape length
+}
+
+// Enumerates all machines for each tape length from `from` to `to` (both
+// included). The other parameters are the same for every tape length,
+// LimitSpace is ignored. `configure` is called on the enumerator walking the
+// trees before it starts, to set its workers, sinks and logs, and may be nil.
+// Its sinks receive the records of all tape lengths. The BB records of each
+// tape length are written to its BBRecordLog once the trees are enumerated,
+// while its progress logs count the machines of all tape lengths.
+func Sweep(params RunParameters, from int, to int, configure func(*Enumerator)) []SweepRow {
+	var rows []SweepRow
+
+	for first := from; first <= to; first += SWEEP_MAX_TAPE_LENGTHS {
+		last := MinI(to, first+SWEEP_MAX_TAPE_LENGTHS-1)
+
+		params.LimitSpace = first
+		leader := NewEnumerator(params)
+		if configure != nil {
+			configure(leader)
+		}
+
+		s := &sweep{}
+		for tapeLength := first; tapeLength <= last; tapeLength += 1 {
+			params.LimitSpace = tapeLength
+			enumerator := NewEnumerator(params)
+			enumerator.HaltingSink = leader.HaltingSink
+			enumerator.UndecidedTimeSink = leader.UndecidedTimeSink
+			enumerator.UndecidedSpaceSink = leader.UndecidedSpaceSink
+			enumerator.BBRecordLog = &bytes.Buffer{}
+			enumerator.ErrorLog = leader.ErrorLog
+			s.enumerators = append(s.enumerators, enumerator)
+		}
+		s.metrics = make([]Metrics, len(s.enumerators))
+
+		leader.sweep = s
+		report := leader.Enumerate()
+
+		for i, enumerator := range s.enumerators {
+			fmt.Fprintf(leader.BBRecordLog, "=== Tape length %d ===\n", enumerator.LimitSpace)
+			enumerator.BBRecordLog.(*bytes.Buffer).WriteTo(leader.BBRecordLog)
+
+			row := SweepRow{TapeLength: enumerator.LimitSpace, Report: report}
+			row.Metrics = s.metrics[i]
+			rows = append(rows, row)
+		}
+	}
+
+	return rows
+}
+
+// Outcome of the simulation of a machine
+type simulation struct {
+	haltStatus  HaltStatus
+	state       byte
+	read        byte
+	steps_count int
+	space_count int
+}
+
+// Simulates tm on the tape lengths of the sweep given by the bits of
+// `lengths`, the others are left zero.
+// A machine which never reaches the last cell of the tape, i.e. whose space
+// count is less than the tape length, neither moves past the right edge nor
+// wraps around the left one. Its run is the same on any longer tape, apart
+// from machines declared NO_HALT for running more than BBtUpperBound steps:
+// they loop forever on the longer tape too, but its time limit may now be
+// reached first.
+func (e *Enumerator) simulateSweep(tm TM, lengths uint64) []simulation {
+	simulations := make([]simulation, len(e.sweep.enumerators))
+
+	var confined *simulation
+	var confinedOn *Enumerator
+	for i, enumerator := range e.sweep.enumerators {
+		if lengths&(1<<uint(i)) == 0 {
+			continue
+		}
+
+		if confined != nil {
+			simulations[i] = *confined
+			if confined.haltStatus == NO_HALT && !e.DetectCycles &&
+				confined.steps_count > confinedOn.BBtUpperBound {
+				if enumerator.BBtUpperBound <= enumerator.LimitTime {
+					simulations[i].steps_count = enumerator.BBtUpperBound + 1
+				} else {
+					simulations[i].haltStatus = UNDECIDED_TIME
+					simulations[i].steps_count = enumerator.LimitTime + 1
+				}
+			}
+			continue
+		}
+
+		var sim simulation
+		sim.haltStatus, sim.state, sim.read, sim.steps_count, sim.space_count, _, _ = enumerator.simulate(tm)
+		simulations[i] = sim
+
+		if sim.space_count > 0 && sim.space_count < enumerator.LimitSpace {
+			confined = &simulations[i]
+			confinedOn = enumerator
+		}
+	}
+	return simulations
+}
+
+// Enumerates the children of the task on each of its tape lengths, as
+// enumerate does. The children reaching the same undefined transition on
+// several tape lengths make a single new task.
+func (e *Enumerator) enumerateSweep(w *worker, t task) {
+	nbStates := e.NbStates
+	nbSymbols := e.NbSymbols
+	enumerators := e.sweep.enumerators
+
+	definedTransitionCount := 0
+	for i := 2; i < TM_SIZE; i += 3 {
+		if t.tm[i] != 0 {
+			definedTransitionCount += 1
+		}
+	}
+	isRoot := definedTransitionCount == 0
+
+	target_states := targetStates(nbStates, nbSymbols, t.tm, t.state)
+	if target_states == nil {
+		return
+	}
+
+	local := make([]Metrics, len(enumerators))
+	localBestSpaceHaltingMachines := make([]TM, len(enumerators))
+
+	for _, target_state := range target_states {
+		for move := byte(0); move <= 1; move += 1 {
+			for write := byte(0); write < nbSymbols; write += 1 {
+				newTm := t.tm
+				newTm[transitionIndex(nbSymbols, t.state, t.read)] = write
+				newTm[transitionIndex(nbSymbols, t.state, t.read)+1] = move
+				newTm[transitionIndex(nbSymbols, t.state, t.read)+2] = target_state
+
+				depth := definedTransitionCount + 1
+				if depth == e.TaskDepth && !e.isMyTask(e.TaskID(newTm)) {
+					continue
+				}
+				counted := depth >= e.TaskDepth || e.isMyTask(0)
+
+				if !isRoot && e.ActivateFiltering && pruneTM(nbStates, nbSymbols, newTm, t.state, t.read, e.Boundary) {
+					if counted {
+						for i := range enumerators {
+							if t.lengths&(1<<uint(i)) != 0 {
+								local[i].NbMachinePruned += 1
+							}
+						}
+					}
+					continue
+				}
+
+				var children []task
+				for i, sim := range e.simulateSweep(newTm, t.lengths) {
+					if t.lengths&(1<<uint(i)) == 0 {
+						continue
+					}
+
+					if sim.haltStatus == HALT && sim.state != H {
+						merged := false
+						for j := range children {
+							if children[j].state == sim.state && children[j].read == sim.read {
+								children[j].lengths |= 1 << uint(i)
+								merged = true
+								break
+							}
+						}
+						if !merged {
+							children = append(children, task{tm: newTm, state: sim.state, read: sim.read,
+								steps_count: sim.steps_count, space_count: sim.space_count, lengths: 1 << uint(i)})
+						}
+					}
+
+					if !counted {
+						continue
+					}
+
+					if sim.haltStatus == HALT && sim.space_count > local[i].MaxSpace {
+						localBestSpaceHaltingMachines[i] = newTm
+					}
+					local[i].addMachine(sim.haltStatus, newTm, sim.steps_count, sim.space_count)
+
+					if sim.haltStatus != NO_HALT {
+						enumerators[i].writeRecord(nil, Record{
+							TM:         newTm,
+							NbStates:   nbStates,
+							NbSymbols:  nbSymbols,
+							HaltStatus: sim.haltStatus,
+							EndState:   sim.state,
+							Read:       sim.read,
+							StepsCount: sim.steps_count,
+							SpaceCount: sim.space_count,
+							TapeLength: enumerators[i].LimitSpace,
+						})
+					}
+				}
+
+				for _, child := range children {
+					e.scheduler.push(w, child)
+				}
+			}
+		}
+	}
+
+	if w.sweepRecords == nil {
+		w.sweepRecords = make([]recordView, len(enumerators))
+	}
+
+	var metrics Metrics
+	e.sweep.mutex.Lock()
+	for i := range enumerators {
+		if t.lengths&(1<<uint(i)) != 0 {
+			e.sweep.metrics[i].add(local[i])
+			metrics.add(local[i])
+		}
+	}
+	e.sweep.mutex.Unlock()
+	w.addMetrics(metrics)
+
+	for i, enumerator := range enumerators {
+		if t.lengths&(1<<uint(i)) != 0 {
+			enumerator.logRecords(&w.sweepRecords[i], local[i].MaxNbSteps, local[i].BBChampion,
+				local[i].MaxSpace, localBestSpaceHaltingMachines[i])
+		}
+	}
+}
+
+// Parses a range of tape lengths of the form "a:b"
+func ParseTapeLengthRange(s string) (from int, to int, err error) {
+	bounds := strings.Split(s, ":")
+	if len(bounds) != 2 {
+		return 0, 0, errors.New("invalid tape length range '" + s + "', must be 'a:b' with 1 <= a <= b")
+	}
+
+	from, errFrom := strconv.Atoi(bounds[0])
+	to, errTo := strconv.Atoi(bounds[1])
+	if errFrom != nil || errTo != nil || from < 1 || to < from {
+		return 0, 0, errors.New("invalid tape length range '" + s + "', must be 'a:b' with 1 <= a <= b")
+	}
+
+	return from, to, nil
+}
+
+var sweepHeaders = []string{"tape length", "bb time", "bb space", "champion",
+	"seen", "pruned", "halt", "non halt", "undecided time", "undecided space"}
+
+func (row SweepRow) fields(nbStates byte, nbSymbols byte) []string {
+	return []string{
+		strconv.Itoa(row.TapeLength),
+		strconv.Itoa(row.MaxNbSteps),
+		strconv.Itoa(row.MaxSpace),
+		row.BBChampion.ToCompactString(nbStates, nbSymbols),
+		strconv.Itoa(row.NbMachineSeen),
+		strconv.Itoa(row.NbMachinePruned),
+		strconv.Itoa(row.NbHaltingMachines),
+		strconv.Itoa(row.NbNonHaltingMachines),
+		strconv.Itoa(row.NbUndecidedTime),
+		strconv.Itoa(row.NbUndecidedSpace),
+	}
+}
+
+func SweepToAsciiTable(rows []SweepRow, nbStates byte, nbSymbols byte) string {
+	var table [][]string
+	for _, row := range rows {
+		table = append(table, row.fields(nbStates, nbSymbols))
+	}
+
+	return AsciiTable(table, sweepHeaders)
+}
+
+func WriteSweepCSV(w io.Writer, rows []SweepRow, nbStates byte, nbSymbols byte) error {
+	csvWriter := csv.NewWriter(w)
+
+	header := make([]string, len(sweepHeaders))
+	for i, name := range sweepHeaders {
+		header[i] = strings.ReplaceAll(name, " ", "_")
+	}
+	csvWriter.Write(header)
+
+	for _, row := range rows {
+		csvWriter.Write(row.fields(nbStates, nbSymbols))
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
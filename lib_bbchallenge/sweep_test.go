@@ -0,0 +1,113 @@
+// Here we test sweeps over tape lengths
+package bbchallenge
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseTapeLengthRange(t *testing.T) {
+	from, to, err := ParseTapeLengthRange("2:12")
+	if err != nil || from != 2 || to != 12 {
+		t.Error(from, to, err)
+	}
+
+	for _, invalid := range []string{"", "3", "0:2", "5:4", "a:b", "1:2:3"} {
+		if _, _, err := ParseTapeLengthRange(invalid); err == nil {
+			t.Error("accepted invalid range", invalid)
+		}
+	}
+}
+
+// Each row of a sweep has the metrics of the enumeration of its tape length
+func TestSweep(t *testing.T) {
+	params := getSmallRunParameters(2, 1, BOUNDARY_STAY)
+	params.LimitTime = 1000
+
+	rows := Sweep(params, 2, 4, nil)
+	if len(rows) != 3 {
+		t.Fatal(len(rows))
+	}
+
+	for i, row := range rows {
+		params.LimitSpace = 2 + i
+		report := NewEnumerator(params).Enumerate()
+
+		if row.TapeLength != 2+i || row.Metrics != report.Metrics {
+			t.Error(row.TapeLength, row.Metrics, report.Metrics)
+		}
+	}
+
+	var csv bytes.Buffer
+	if err := WriteSweepCSV(&csv, rows, 2, 2); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(csv.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[1], "2,") {
+		t.Error(lines)
+	}
+}
+
+// Machines simulated once for several tape lengths must be counted as if they
+// were simulated on each of them, whatever the boundary, the time limit
+// (reached before the configuration bound from tape length 7 on) and the
+// split of the enumeration in tasks
+func TestSweepReuse(t *testing.T) {
+	for boundary := BOUNDARY_STAY; boundary <= BOUNDARY_WRAP; boundary += 1 {
+		for _, detectCycles := range []bool{false, true} {
+			params := getSmallRunParameters(3, 1, boundary)
+			params.LimitTime = 2000
+			params.DetectCycles = detectCycles
+			params.TaskDivisor = 3
+			params.TaskDepth = 3
+			params.MyTasks = []int{0, 2}
+
+			for i, row := range Sweep(params, 1, 7, nil) {
+				params.LimitSpace = 1 + i
+				report := NewEnumerator(params).Enumerate()
+
+				if row.Metrics != report.Metrics {
+					t.Error(boundary, detectCycles, row.TapeLength, row.Metrics, report.Metrics)
+				}
+			}
+		}
+	}
+}
+
+// The records of every tape length go to the sinks, and the BB records of
+// each tape length are logged after each other
+func TestSweepOutputs(t *testing.T) {
+	params := getSmallRunParameters(2, 1, BOUNDARY_STAY)
+
+	var halting, bbRecords bytes.Buffer
+	rows := Sweep(params, 1, SWEEP_MAX_TAPE_LENGTHS+2, func(e *Enumerator) {
+		e.HaltingSink = ExtendedSink{&lockedWriter{w: &halting}}
+		e.BBRecordLog = &bbRecords
+	})
+
+	nbHalting := 0
+	for _, row := range rows {
+		nbHalting += row.NbHaltingMachines
+	}
+	if len(rows) != SWEEP_MAX_TAPE_LENGTHS+2 || halting.Len() != nbHalting*EXTENDED_RECORD_SIZE {
+		t.Error(len(rows), halting.Len(), nbHalting)
+	}
+
+	last := rows[len(rows)-1]
+	params.LimitSpace = last.TapeLength
+	if report := NewEnumerator(params).Enumerate(); last.Metrics != report.Metrics {
+		t.Error(last.Metrics, report.Metrics)
+	}
+
+	if strings.Count(bbRecords.String(), "=== Tape length") != len(rows) ||
+		!strings.Contains(bbRecords.String(), "=== Tape length 2 ===\n*TIME") {
+		t.Error(bbRecords.String()[:200])
+	}
+}
+
+func TestToCompactString(t *testing.T) {
+	if s := getBB2Winner().ToCompactString(2, 2); s != "1RB1LB_1LA1RZ" {
+		t.Error(s)
+	}
+}
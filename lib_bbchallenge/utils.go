@@ -3,10 +3,52 @@ package bbchallenge
 import (
 	"io/ioutil"
 	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rgeoghegan/tabulate"
 )
 
+// Name of a new run, from the current date and time
+func GetRunName() string {
+	// I'll be running this many times with different memory limits so I
+	// changed this to make it easier to tell which run is which.
+	timestamp := time.Now().Format(time.DateTime)
+
+	// Get rid of annoying characters
+	timestamp = strings.Replace(timestamp, " ", "_", -1)
+	timestamp = strings.Replace(timestamp, ":", "-", -1)
+
+	return "run_" + timestamp
+}
+
 func InitAppendFile(logFileName string, outputDirectory string) *os.File {
 	ioutil.WriteFile(outputDirectory+logFileName, []byte(""), 0644)
 	logFile, _ := os.OpenFile(outputDirectory+logFileName, os.O_APPEND|os.O_WRONLY, 0644)
 	return logFile
 }
+
+// Same as InitAppendFile but keeps the current content of the file, if any
+func OpenAppendFile(logFileName string, outputDirectory string) *os.File {
+	logFile, _ := os.OpenFile(outputDirectory+logFileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	return logFile
+}
+
+// tabulate keeps the widths of the columns in global variables, tables drawn
+// from several go routines (e.g. in traces) must be drawn one at a time
+var mutexTabulate sync.Mutex
+
+// Draws the rows of the table under the given headers, in the format of the
+// summaries printed by the tools of this repository
+func AsciiTable(table [][]string, headers []string) string {
+	mutexTabulate.Lock()
+	defer mutexTabulate.Unlock()
+
+	layout := &tabulate.Layout{Headers: headers, Format: tabulate.SimpleFormat}
+	asText, _ := tabulate.Tabulate(
+		table, layout,
+	)
+
+	return asText
+}
@@ -26,7 +26,7 @@ func TestAppendAtomic(t *testing.T) {
 	for i := 0; i < 1000000; i += 1 {
 		wg.Add(1)
 		go func(i int) {
-			testFile.Write([]byte(tms[i%2][:]))
+			testFile.Write(tms[i%2][:LEGACY_TM_SIZE])
 			wg.Done()
 		}(i)
 	}
@@ -3,13 +3,15 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math"
 	"os"
+	"runtime"
 	"strings"
 	"time"
 
 	bbc "github.com/bbchallenge/bbchallenge/lib_bbchallenge"
-	bbchallenge "github.com/bbchallenge/bbchallenge/lib_bbchallenge"
 	"github.com/sirupsen/logrus"
 	log "github.com/sirupsen/logrus"
 )
@@ -27,134 +29,538 @@ func (f *BBChallengeFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	return []byte(entry.Message + "\n"), nil
 }
 
-func getRunName() string {
-	// I'll be running this many times with different memory limits so I
-	// changed this to make it easier to tell which run is which.
-	timestamp := time.Now().Format(time.DateTime)
-
-	// Get rid of annoying characters
-	timestamp = strings.Replace(timestamp, " ", "_", -1)
-	timestamp = strings.Replace(timestamp, ":", "-", -1)
-
-	return "run_" + timestamp
-}
-
 var undecidedTimeFile *os.File
 var haltingFile *os.File
 var undecidedSpaceFile *os.File
 var bbRecordFile *os.File
 
-func initLogger(runName string) {
+// Extension of the files holding records in the given format
+func recordFileExtension(format bbc.RecordFormat) string {
+	switch format {
+	case bbc.FORMAT_CSV:
+		return ".csv"
+	case bbc.FORMAT_JSONL:
+		return ".jsonl"
+	}
+	return "" // binary file
+}
+
+// Sink writing records to the given file, a header is only written to
+// files that are still empty
+func newFileSink(format bbc.RecordFormat, file *os.File) bbc.ResultSink {
+	withHeader := true
+	if info, err := file.Stat(); err == nil {
+		withHeader = info.Size() == 0
+	}
+	return bbc.NewResultSink(format, file, withHeader)
+}
+
+func initLogger(enumerator *bbc.Enumerator, runName string, resume bool) {
+
+	// When resuming, we keep appending to the files of the interrupted run
+	openFile := bbc.InitAppendFile
+	if resume {
+		openFile = bbc.OpenAppendFile
+	}
 
 	mainLogFileName := runName + ".txt"
 	log.SetFormatter(new(BBChallengeFormatter))
-	log.SetOutput(bbchallenge.InitAppendFile(mainLogFileName, "output/"))
+	log.SetOutput(openFile(mainLogFileName, "output/"))
 
-	haltingLogFileName := runName + "_halting" // binary file
-	bbc.HaltingLog = bbchallenge.InitAppendFile(haltingLogFileName, "output/")
+	format := enumerator.Format
+	extension := recordFileExtension(format)
 
-	undecidedTimeLogFileName := runName + "_undecided_time" // binary file
-	bbc.UndecidedTimeLog = bbchallenge.InitAppendFile(undecidedTimeLogFileName, "output/")
+	haltingLogFileName := runName + "_halting" + extension
+	haltingFile = openFile(haltingLogFileName, "output/")
+	enumerator.HaltingSink = newFileSink(format, haltingFile)
 
-	undecidedSpaceLogFileName := runName + "_undecided_space" // binary file
-	bbc.UndecidedSpaceLog = bbchallenge.InitAppendFile(undecidedSpaceLogFileName, "output/")
+	undecidedTimeLogFileName := runName + "_undecided_time" + extension
+	undecidedTimeFile = openFile(undecidedTimeLogFileName, "output/")
+	enumerator.UndecidedTimeSink = newFileSink(format, undecidedTimeFile)
+
+	undecidedSpaceLogFileName := runName + "_undecided_space" + extension
+	undecidedSpaceFile = openFile(undecidedSpaceLogFileName, "output/")
+	enumerator.UndecidedSpaceSink = newFileSink(format, undecidedSpaceFile)
 
 	bbRecordLogFileName := runName + "_bb_records.txt"
-	bbc.BBRecordLog = bbchallenge.InitAppendFile(bbRecordLogFileName, "output/")
+	bbRecordFile = openFile(bbRecordLogFileName, "output/")
+	enumerator.BBRecordLog = bbRecordFile
+
+	enumerator.ErrorLog = io.MultiWriter(os.Stderr, log.StandardLogger().Out)
+}
+
+func logMetrics(nbStates byte, metrics bbc.Metrics) {
+	log.Info(fmt.Sprintf("Number of %d-state machines seen: %d", nbStates, metrics.NbMachineSeen))
+	log.Info(fmt.Sprintf("Number of %d-state machines pruned: %d (%.2f)", nbStates, metrics.NbMachinePruned, float64(metrics.NbMachinePruned)/float64(metrics.NbMachineSeen)))
+	log.Info(fmt.Sprintf("Number of halting machines: %d (%.2f)", metrics.NbHaltingMachines, float64(metrics.NbHaltingMachines)/float64(metrics.NbMachineSeen)))
+	log.Info(fmt.Sprintf("Number of non-halting machines: %d (%.2f)", metrics.NbNonHaltingMachines, float64(metrics.NbNonHaltingMachines)/float64(metrics.NbMachineSeen)))
+	log.Info(fmt.Sprintf("Number of undecided-time machines: %d (%.2f)", metrics.NbUndecidedTime, float64(metrics.NbUndecidedTime)/float64(metrics.NbMachineSeen)))
+	log.Info(fmt.Sprintf("Number of undecided-space machines: %d (%.2f)\n", metrics.NbUndecidedSpace, float64(metrics.NbUndecidedSpace)/float64(metrics.NbMachineSeen)))
+
+	log.Info(fmt.Sprintf("BB%d estimate: %d", nbStates, metrics.MaxNbSteps))
+	log.Info(fmt.Sprintf("BB%d_SPACE estimate: %d\n", nbStates, metrics.MaxSpace))
+}
+
+// Writes the parameters and counts of the run to output/<runName>_header.json
+// and, for legacy files, the header of its undecided machines which records
+// the enumeration parameters in the database built by the merge subcommand
+func writeRunHeader(runName string, params bbc.RunParameters, metrics bbc.Metrics) {
+	if err := bbc.WriteRunHeader("output/"+runName+"_header.json", params.RunHeader(metrics)); err != nil {
+		log.Info("Cannot write the header of the run: ", err)
+	}
+
+	if params.Format != bbc.FORMAT_LEGACY {
+		return
+	}
+
+	header := params.DatabaseHeader(metrics)
+	if err := ioutil.WriteFile("output/"+runName+"_header", header.Encode(), 0644); err != nil {
+		log.Info("Cannot write the header of the run: ", err)
+	}
+}
+
+// Loads the checkpoint of an interrupted run and discards everything that was
+// written to its output files after that checkpoint
+func loadCheckpoint(runName string) bbc.Checkpoint {
+	checkpoint, err := bbc.LoadCheckpoint("output/" + runName + "_checkpoint.json")
+	if err != nil {
+		fmt.Println("Cannot resume run", runName, ":", err)
+		os.Exit(-1)
+	}
+
+	if checkpoint.Finished {
+		fmt.Println("Run", runName, "is already finished.")
+		os.Exit(-1)
+	}
+
+	truncations := map[string]int64{
+		"_halting":         checkpoint.HaltingSize,
+		"_undecided_time":  checkpoint.UndecidedTimeSize,
+		"_undecided_space": checkpoint.UndecidedSpaceSize,
+	}
+
+	for suffix, size := range truncations {
+		fileName := "output/" + runName + suffix + recordFileExtension(checkpoint.Parameters.Format)
+		if err := os.Truncate(fileName, size); err != nil {
+			fmt.Println("Cannot resume run", runName, ":", err)
+			os.Exit(-1)
+		}
+	}
+
+	return checkpoint
+}
+
+// Runs the enumeration for each tape length from `from` to `to` and writes
+// the summary table, as text and CSV. Only the BB records are logged, the
+// halting and undecided machines are not written.
+func sweep(params bbc.RunParameters, runName string, from int, to int, nbWorkers int, verbose bool, logFreq int64) {
+	mainLogFile := bbc.InitAppendFile(runName+".txt", "output/")
+	log.SetFormatter(new(BBChallengeFormatter))
+	log.SetOutput(mainLogFile)
+
+	bbRecordFile = bbc.InitAppendFile(runName+"_bb_records.txt", "output/")
+
+	log.Info(runName)
+	log.Info(time.Now().Format(time.RFC1123))
+	log.Info("Nb states: ", params.NbStates)
+	log.Info("Nb symbols: ", params.NbSymbols)
+	log.Info("Limit time: ", params.LimitTime)
+	log.Info("Limit space: ", from, " to ", to)
+	log.Info("Boundary: ", params.Boundary)
+	log.Info("Cycle detection: ", params.DetectCycles)
+	log.Info("Workers: ", nbWorkers)
+
+	rows := bbc.Sweep(params, from, to, func(enumerator *bbc.Enumerator) {
+		enumerator.NbWorkers = nbWorkers
+		enumerator.Verbose = verbose
+		enumerator.LogFreq = logFreq
+		enumerator.BBRecordLog = bbRecordFile
+	})
+
+	summary := bbc.SweepToAsciiTable(rows, params.NbStates, params.NbSymbols)
+	log.Info("\n" + summary)
+
+	summaryFile := bbc.InitAppendFile(runName+"_sweep.txt", "output/")
+	summaryFile.WriteString(summary)
+	summaryFile.Close()
+
+	csvFile := bbc.InitAppendFile(runName+"_sweep.csv", "output/")
+	if err := bbc.WriteSweepCSV(csvFile, rows, params.NbStates, params.NbSymbols); err != nil {
+		log.Info("Cannot write the CSV summary: ", err)
+	}
+	csvFile.Close()
+
+	bbRecordFile.Close()
+	mainLogFile.Close()
+}
+
+// Flag set of a subcommand, whose usage shows the arguments expected after
+// the flags
+func newFlagSet(subcommand string, arguments string) *flag.FlagSet {
+	flags := flag.NewFlagSet(subcommand, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage of %s %s [flags]%s:\n", os.Args[0], subcommand, arguments)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// Flags of the enumeration parameters, shared by runs and the coordinator
+type enumerationFlags struct {
+	nbStates         *int
+	nbSymbols        *int
+	backend          *int
+	limitSpace       *int
+	limitTime        *int
+	taskDivisor      *int
+	taskDepth        *int
+	disableFiltering *bool
+	boundary         *string
+	detectCycles     *bool
+	format           *string
+}
+
+func addEnumerationFlags(flags *flag.FlagSet) enumerationFlags {
+	return enumerationFlags{
+		nbStates:         flags.Int("n", 4, "# of states, up to 8"),
+		nbSymbols:        flags.Int("m", 2, "# of symbols"),
+		backend:          flags.Int("b", 0, "simulation backend (0 for go, 1 for C)"),
+		limitSpace:       flags.Int("slim", 10, "LBA memory capacity"),
+		limitTime:        flags.Int("tlim", math.MaxInt, "time limit after which running machines are killed and marked as 'UNDECIDED_TIME' (leave blank to use the upper bound 2^t*t*n, for tape length t and number of states n)"),
+		taskDivisor:      flags.Int("divtask", 1, "divides the job in this number of tasks, see -taskdepth and -mytask"),
+		taskDepth:        flags.Int("taskdepth", 5, "depth (number of defined transitions) at which the enumeration tree is cut in tasks, each subtree at this depth belongs to one task"),
+		disableFiltering: flags.Bool("nf", false, "disable extra pruning of redundant machines from the enumeration"),
+		boundary:         flags.String("boundary", "stay", "what happens when the head moves past an edge of the tape: 'stay' (head stays put), 'halt' (machine halts), 'reject' (machine loops forever) or 'wrap' (circular tape)"),
+		detectCycles:     flags.Bool("cycles", false, "decide non-halting machines as soon as they repeat a configuration instead of running them up to the upper bound (Go backend only)"),
+		format:           flags.String("format", "legacy", "format of the halting and undecided machines files: 'legacy' (30-byte machines), 'extended' (118-byte versioned records with halt status, end state, read symbol, steps, space and tape length), 'csv' or 'jsonl' (JSON Lines)"),
+	}
+}
+
+// Enumeration parameters given by the flags, exits if they cannot be parsed
+func (f enumerationFlags) parameters() bbc.RunParameters {
+	boundary, err := bbc.ParseBoundaryMode(*f.boundary)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	format, err := bbc.ParseRecordFormat(*f.format)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	if *f.taskDivisor < 1 {
+		fmt.Println("Task divisor must be at least 1. Default is 1.")
+		os.Exit(-1)
+	}
+
+	return bbc.RunParameters{
+		NbStates:          byte(*f.nbStates),
+		NbSymbols:         byte(*f.nbSymbols),
+		LimitTime:         *f.limitTime,
+		LimitSpace:        *f.limitSpace,
+		Boundary:          boundary,
+		ActivateFiltering: !*f.disableFiltering,
+		DetectCycles:      *f.detectCycles,
+		Backend:           bbc.SimulationBackend(*f.backend),
+		TaskDivisor:       *f.taskDivisor,
+		TaskDepth:         *f.taskDepth,
+		Format:            format,
+	}
+}
+
+// Exits if the enumeration parameters are invalid, they may come from a
+// checkpoint instead of the flags
+func checkParameters(params bbc.RunParameters) {
+	if params.NbSymbols < 2 || params.NbSymbols > bbc.MAX_SYMBOLS {
+		fmt.Println("Number of symbols must be between 2 and", bbc.MAX_SYMBOLS)
+		os.Exit(-1)
+	}
+
+	if params.NbStates < 1 || params.NbStates > bbc.MAX_STATES {
+		fmt.Println("Number of states must be between 1 and", bbc.MAX_STATES)
+		os.Exit(-1)
+	}
+
+	if params.LimitSpace < 1 {
+		fmt.Println("Tape length must be at least 1.")
+		os.Exit(-1)
+	}
+
+	if (params.NbStates > 5 || params.NbSymbols != 2) && params.Format == bbc.FORMAT_LEGACY {
+		fmt.Println("The legacy format only supports <= 5-state 2-symbol machines, use -format extended, csv or jsonl.")
+		os.Exit(-1)
+	}
+
+	if params.TaskDivisor > 1 && params.TaskDepth < 1 {
+		fmt.Println("Task depth must be at least 1.")
+		os.Exit(-1)
+	}
+
+	// No machine is cut deeper, all of them would belong to task 0
+	nbTransitions := int(params.NbStates) * int(params.NbSymbols)
+	if params.TaskDivisor > 1 && params.TaskDepth >= nbTransitions {
+		fmt.Println("Task depth must be less than the number of transitions, which is", nbTransitions, "for", params.NbStates, "states and", params.NbSymbols, "symbols.")
+		os.Exit(-1)
+	}
+
+	if params.Backend != bbc.SIMULATION_GO && params.Backend != bbc.SIMULATION_C {
+		fmt.Println("Simulation backend must be 0 (go) or 1 (C).")
+		os.Exit(-1)
+	}
+
+	if params.DetectCycles && params.Backend != bbc.SIMULATION_GO {
+		fmt.Println("Cycle detection is only available with the Go backend.")
+		os.Exit(-1)
+	}
+}
+
+// Number of workers given by the -workers flag
+func numberOfWorkers(nbWorkers int) int {
+	if nbWorkers < 0 {
+		fmt.Println("Number of workers must be positive, or 0 to use GOMAXPROCS.")
+		os.Exit(-1)
+	}
+	if nbWorkers == 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return nbWorkers
 }
 
+const workersUsage = "number of go routines enumerating machines in parallel (default GOMAXPROCS, i.e. the number of CPUs)"
+const haltSymbolUsage = "letter of the halt state in the text notation of machines"
+
 func main() {
-	runName := getRunName()
-	initLogger(runName)
+	// Subcommands come before the flags
+	subcommand := ""
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		subcommand = args[0]
+		args = args[1:]
+	}
+
+	switch subcommand {
+	case "":
+		enumerateMain(args)
+	case "coordinator":
+		flags := newFlagSet("coordinator", "")
+		enumeration := addEnumerationFlags(flags)
+		arg_coordinator_addr := flags.String("addr", ":8080", "address to listen on")
+		arg_lease := flags.Int("lease", 300, "seconds after which the task of a worker that stopped sending heartbeats is handed out again")
+		flags.Parse(args)
+
+		params := enumeration.parameters()
+		checkParameters(params)
+		if *arg_lease < 1 {
+			fmt.Println("Lease duration must be at least 1 second.")
+			os.Exit(-1)
+		}
+
+		coordinate(params, bbc.GetRunName(), *arg_coordinator_addr, time.Duration(*arg_lease)*time.Second)
+	case "worker":
+		flags := newFlagSet("worker", "")
+		arg_coordinator_url := flags.String("coordinator", "http://localhost:8080", "URL of the coordinator")
+		arg_worker_name := flags.String("name", "", "name of the worker in the logs of the coordinator (default hostname-pid)")
+		arg_workers := flags.Int("workers", 0, workersUsage)
+		flags.Parse(args)
+
+		distributedWorker(*arg_coordinator_url, *arg_worker_name, numberOfWorkers(*arg_workers))
+	case "merge":
+		flags := newFlagSet("merge", " <run name>...")
+		arg_database := flags.String("db", "all_5_states_undecided_machines_with_global_header", "database file to write")
+		flags.Parse(args)
+
+		mergeRuns(flags.Args(), *arg_database)
+	case "export", "import":
+		arguments := " <database or run file>"
+		if subcommand == "import" {
+			arguments = " <text file> <run file>"
+		}
+		flags := newFlagSet(subcommand, arguments)
+		arg_halt_symbol := flags.String("halt", "Z", haltSymbolUsage)
+		flags.Parse(args)
+
+		if subcommand == "export" {
+			exportMachines(flags.Args(), *arg_halt_symbol)
+		} else {
+			importMachines(flags.Args(), *arg_halt_symbol)
+		}
+	case "simulate", "debug":
+		flags := newFlagSet(subcommand, " <machine>")
+		arg_limit_space := flags.Int("slim", 10, "LBA memory capacity")
+		arg_boundary := flags.String("boundary", "stay", "what happens when the head moves past an edge of the tape: 'stay', 'halt', 'reject' or 'wrap'")
+		arg_halt_symbol := flags.String("halt", "Z", haltSymbolUsage)
+		var arg_limit_time, arg_diagram_rows *int
+		var arg_diagram *string
+		if subcommand == "simulate" {
+			arg_limit_time = flags.Int("tlim", math.MaxInt, "time limit after which the machine is stopped (leave blank to use the upper bound)")
+			arg_diagram = flags.String("diagram", "", "prints the space-time diagram with 'ascii', or writes it to the given .png or .svg file")
+			arg_diagram_rows = flags.Int("rows", 1000, "maximal number of steps in the space-time diagram, 0 to only print the results without the final tape")
+		}
+		flags.Parse(args)
+
+		boundary, err := bbc.ParseBoundaryMode(*arg_boundary)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+
+		if subcommand == "debug" {
+			if *arg_limit_space < 1 {
+				fmt.Println("Tape length must be at least 1.")
+				os.Exit(-1)
+			}
+			debugMachine(flags.Args(), *arg_halt_symbol, *arg_limit_space, boundary)
+			return
+		}
+
+		if *arg_limit_space < 1 || *arg_limit_time < 0 || *arg_diagram_rows < 0 {
+			fmt.Println("Tape length must be at least 1, time limit and number of rows must be positive.")
+			os.Exit(-1)
+		}
+		simulateMachine(flags.Args(), *arg_halt_symbol, *arg_limit_time, *arg_limit_space, boundary, *arg_diagram, *arg_diagram_rows)
+	default:
+		fmt.Println("Unknown subcommand '" + subcommand + "', must be 'coordinator', 'worker', 'merge', 'export', 'import', 'simulate' or 'debug'.")
+		os.Exit(-1)
+	}
+}
+
+// Enumeration run without subcommand, which may list its tasks, sweep tape
+// lengths or resume an interrupted run
+func enumerateMain(args []string) {
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage of %s [flags], or %s <subcommand> -h for the flags of a subcommand among coordinator, worker, merge, export, import, simulate and debug:\n", os.Args[0], os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	enumeration := addEnumerationFlags(flags)
+	arg_workers := flags.Int("workers", 0, workersUsage)
+	arg_verb := flags.Bool("v", false, "displays infos about the current run on stdout")
+	arg_verb_freq := flags.Int("vf", 30, "seconds between each stdout log in verbose mode")
 
-	arg_nbStates := flag.Int("n", 4, "# of states")
-	arg_backend := flag.Int("b", 0, "simulation backend (0 for go, 1 for C)")
-	arg_verb := flag.Bool("v", false, "displays infos about the current run on stdout")
-	arg_verb_freq := flag.Int("vf", 30, "seconds between each stdout log in verbose mode")
+	arg_list := flags.Bool("list", false, "lists all simulated machines")
 
-	arg_list := flag.Bool("list", false, "lists all simulated machines")
+	arg_slim_range := flags.String("slim-range", "", "sweep mode: enumerates all machines for each LBA memory capacity in the range 'a:b' (overrides -slim) and writes a summary table of the busy beaver values")
 
-	arg_limit_space := flag.Int("slim", 10, "LBA memory capacity")
-	arg_limit_time := flag.Int("tlim", math.MaxInt, "time limit after which running machines are killed and marked as 'UNDECIDED_TIME' (leave blank to use the upper bound 2^t*t*n, for tape length t and number of states n)")
+	arg_list_tasks := flags.Bool("listtasks", false, "lists the tasks with their estimated sizes and exits")
+	arg_my_tasks := flags.String("mytask", "0", "select which tasks this run will do: a task id, a range or a list such as '1,4,7-9'")
 
-	arg_task_divisor := flag.Int("divtask", 1, "divides the size of the job by 1, 2, 4 or 8")
+	arg_checkpoint_freq := flags.Int("cpf", 60, "seconds between each checkpoint")
+	arg_checkpoint_depth := flags.Int("cpdepth", 3, "depth (number of defined transitions) of the enumeration subtrees whose completion is recorded in checkpoints, at least 2")
+	arg_resume := flags.String("resume", "", "name of an interrupted run to resume (e.g. run_2025-03-06_13-46-57), its enumeration parameters are read from its checkpoint")
 
-	arg_disable_filtering := flag.Bool("nf", false, "disable extra pruning of redundant machines from the enumeration")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		fmt.Println("Unexpected argument '" + flags.Arg(0) + "', subcommands come before the flags.")
+		os.Exit(-1)
+	}
 
-	if !(*arg_task_divisor == 1 || *arg_task_divisor == 2 || *arg_task_divisor == 4 || *arg_task_divisor == 8) {
+	params := enumeration.parameters()
 
-		fmt.Println("Task divisor must be either 1, 2, 4 or 8. Default is 1.")
+	myTasks, err := bbc.ParseTaskList(*arg_my_tasks, params.TaskDivisor)
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(-1)
 	}
+	params.MyTasks = myTasks
+	params.CheckpointDepth = *arg_checkpoint_depth
+
+	runName := bbc.GetRunName()
+	resume := *arg_resume != ""
+	var checkpoint bbc.Checkpoint
+
+	if resume {
+		runName = *arg_resume
+		checkpoint = loadCheckpoint(runName)
+		params = checkpoint.Parameters
+	}
 
-	arg_task_divisor_me := flag.Int("mytask", 0, "select which task bucket this run will do")
+	checkParameters(params)
 
-	if *arg_task_divisor_me < 0 || *arg_task_divisor_me >= *arg_task_divisor {
-		fmt.Println("Your task id must be either >= 0 and < the task divisor which is", *arg_task_divisor)
+	if params.CheckpointDepth < 2 {
+		fmt.Println("Checkpoint depth must be at least 2.")
 		os.Exit(-1)
 	}
 
-	flag.Parse()
+	nbWorkers := numberOfWorkers(*arg_workers)
+
+	if *arg_list_tasks {
+		fmt.Print(bbc.TasksToAsciiTable(bbc.EstimateTasks(params, 10, 0)))
+		return
+	}
+
+	if *arg_slim_range != "" {
+		if resume {
+			fmt.Println("Sweeps cannot be resumed.")
+			os.Exit(-1)
+		}
 
-	bbc.TimeStart = time.Now()
+		from, to, err := bbc.ParseTapeLengthRange(*arg_slim_range)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
 
-	// Making the initial transition 1RB actually loses quite a bit of generality in this case
-	kick_start := bbc.TM{
-		0, 0, 0, 0, 0, 0,
-		0, 0, 0, 0, 0, 0,
-		0, 0, 0, 0, 0, 0,
-		0, 0, 0, 0, 0, 0,
-		0, 0, 0, 0, 0, 0,
+		sweep(params, runName, from, to, nbWorkers, *arg_verb, int64(*arg_verb_freq)*1e9)
+		return
 	}
 
-	nbStates := byte(*arg_nbStates)
-	simulationBackend := bbc.SimulationBackend(*arg_backend)
+	enumerator := bbc.NewEnumerator(params)
+	enumerator.NbWorkers = nbWorkers
+	enumerator.Verbose = *arg_verb
+	enumerator.LogFreq = int64(*arg_verb_freq) * 1e9
+	enumerator.ListAll = *arg_list
+	enumerator.CheckpointPath = "output/" + runName + "_checkpoint.json"
+	enumerator.CheckpointFreq = int64(*arg_checkpoint_freq) * 1e9
+
+	if resume {
+		if err := enumerator.ResumeFromCheckpoint(checkpoint); err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+	}
+
+	initLogger(enumerator, runName, resume)
+
+	nbStates := params.NbStates
 
 	log.Info(runName)
 	log.Info(time.Now().Format(time.RFC1123))
+	if resume {
+		log.Info("Resuming from checkpoint of ", checkpoint.Time, " (", len(checkpoint.Completed), " completed subtrees)")
+	}
 	log.Info("Nb states: ", nbStates)
+	log.Info("Nb symbols: ", params.NbSymbols)
 
-	bbc.Verbose = *arg_verb
-	bbc.LogFreq = int64(*arg_verb_freq) * 1e9
-	bbc.ListAll = *arg_list
-	bbc.BBtUpperBound = int(math.Pow(2, float64(*arg_limit_space))) * *arg_limit_space * int(nbStates)
-	bbc.SimulationLimitTime = *arg_limit_time
-	bbc.SimulationLimitSpace = *arg_limit_space
-	bbc.SlowDownInit = 2
-	bbc.ActivateFiltering = !*arg_disable_filtering
+	log.Info("Task divisor: ", params.TaskDivisor)
+	log.Info("Task depth: ", params.TaskDepth)
+	log.Info("My tasks: ", params.MyTasks)
 
-	bbc.TaskDivisor = *arg_task_divisor
-	bbc.TaskDivisorMe = *arg_task_divisor_me
+	log.Info("Limit time: ", params.LimitTime)
+	log.Info("Limit space: ", params.LimitSpace)
+	log.Info("Boundary: ", params.Boundary)
+	log.Info("Record format: ", params.Format)
 
-	log.Info("Task divisor: ", bbc.TaskDivisor)
-	log.Info("My task: ", bbc.TaskDivisorMe)
-
-	log.Info("Limit time: ", bbc.SimulationLimitTime)
-	log.Info("Limit space: ", bbc.SimulationLimitSpace)
-
-	if simulationBackend == bbc.SIMULATION_GO {
+	if params.Backend == bbc.SIMULATION_GO {
 		log.Info("Simulation backend: GO")
 	} else {
 		log.Info("Simulation backend: C")
 	}
+	log.Info("Cycle detection: ", params.DetectCycles)
+	log.Info("Workers: ", nbWorkers)
 
-	bbc.Enumerate(nbStates, kick_start, 1, 0, 0, 0, bbc.SlowDownInit, simulationBackend)
+	report := enumerator.Enumerate()
+	writeRunHeader(runName, params, report.Metrics)
 
 	log.Infoln("\nReport")
 	log.Infoln("======")
 
-	log.Info("Run time: ", time.Since(bbc.TimeStart), "\n")
-	log.Info(fmt.Sprintf("Number of %d-state machines seen: %d", nbStates, bbc.NbMachineSeen))
-	log.Info(fmt.Sprintf("Number of %d-state machines pruned: %d (%.2f)", nbStates, bbc.NbMachinePruned, float64(bbc.NbMachinePruned)/float64(bbc.NbMachineSeen)))
-	log.Info(fmt.Sprintf("Number of halting machines: %d (%.2f)", bbc.NbHaltingMachines, float64(bbc.NbHaltingMachines)/float64(bbc.NbMachineSeen)))
-	log.Info(fmt.Sprintf("Number of non-halting machines: %d (%.2f)", bbc.NbNonHaltingMachines, float64(bbc.NbNonHaltingMachines)/float64(bbc.NbMachineSeen)))
-	log.Info(fmt.Sprintf("Number of undecided-time machines: %d (%.2f)", bbc.NbUndecidedTime, float64(bbc.NbUndecidedTime)/float64(bbc.NbMachineSeen)))
-	log.Info(fmt.Sprintf("Number of undecided-space machines: %d (%.2f)\n", bbc.NbUndecidedSpace, float64(bbc.NbUndecidedSpace)/float64(bbc.NbMachineSeen)))
-
-	log.Info(fmt.Sprintf("BB%d estimate: %d", nbStates, bbc.MaxNbSteps))
-	log.Info(fmt.Sprintf("BB%d_SPACE estimate: %d\n", nbStates, bbc.MaxSpace))
+	log.Info("Run time: ", report.RunTime, "\n")
+	logMetrics(nbStates, report.Metrics)
 
-	log.Info("Max # of simultaneous Go routines during search: ", bbc.MaxNbGoRoutines)
+	log.Info("Workers: ", report.NbWorkers, " (", report.NbStolenTasks, " stolen tasks)")
 	log.StandardLogger().Writer().Close()
 
 	haltingFile.Close()
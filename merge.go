@@ -0,0 +1,102 @@
+// Here we merge the undecided machines of several runs into the database,
+// see lib_bbchallenge/database.go for its format
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	bbc "github.com/bbchallenge/bbchallenge/lib_bbchallenge"
+)
+
+func mergeRuns(runNames []string, databasePath string) {
+	if len(runNames) == 0 {
+		fmt.Println("Give the names of the runs to merge, e.g. 'merge run_2025-03-06_13-46-57 run_2025-03-07_09-12-01'.")
+		os.Exit(-1)
+	}
+
+	var undecidedTime, undecidedSpace []string
+	var headers []bbc.DatabaseHeader
+	for _, runName := range runNames {
+		undecidedTime = append(undecidedTime, "output/"+runName+"_undecided_time")
+		undecidedSpace = append(undecidedSpace, "output/"+runName+"_undecided_space")
+		headers = append(headers, readRunHeader(runName))
+	}
+
+	header, err := bbc.MergeHeaderParameters(headers)
+	if err != nil {
+		fmt.Println("Cannot merge the runs:", err)
+		os.Exit(-1)
+	}
+	if header.Version == 0 {
+		fmt.Println("Warning: the enumeration parameters of the runs are unknown, they will not be recorded in the database")
+	}
+
+	file, err := os.Create(databasePath)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	report, err := bbc.MergeDatabase(file, header, undecidedTime, undecidedSpace)
+	file.Close()
+	if err != nil {
+		fmt.Println("Cannot merge the runs:", err)
+		os.Remove(databasePath)
+		os.Exit(-1)
+	}
+
+	fmt.Println("Undecided time:", report.Header.NbUndecidedTime, "machines,", report.NbDuplicateUndecidedTime, "duplicates removed")
+	fmt.Println("Undecided space:", report.Header.NbUndecidedSpace, "machines,", report.NbDuplicateUndecidedSpace, "duplicates removed")
+	fmt.Println("Total:", report.Header.NbMachines, "machines")
+
+	file, err = os.Open(databasePath)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	defer file.Close()
+	if _, err := bbc.VerifyDatabase(file); err != nil {
+		fmt.Println("Invalid database:", err)
+		os.Exit(-1)
+	}
+	fmt.Println("Database written to", databasePath, "and verified")
+}
+
+// Reads the header written at the end of a run and checks it against the
+// run files. Runs made before headers were written get a version 0 header.
+func readRunHeader(runName string) bbc.DatabaseHeader {
+	var header bbc.DatabaseHeader
+	content, err := ioutil.ReadFile("output/" + runName + "_header")
+	if err == nil {
+		header, err = bbc.DecodeDatabaseHeader(content)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		fmt.Println(runName+":", err)
+		os.Exit(-1)
+	}
+
+	if header.Version == 0 {
+		return header
+	}
+
+	counts := map[string]int{
+		"_undecided_time":  header.NbUndecidedTime,
+		"_undecided_space": header.NbUndecidedSpace,
+	}
+	for suffix, count := range counts {
+		run, err := bbc.OpenRunFile("output/" + runName + suffix)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		run.Close()
+		if run.Len() != count {
+			fmt.Println("output/"+runName+suffix, "holds", run.Len(), "machines but the run found", count, "(was it interrupted?)")
+			os.Exit(-1)
+		}
+	}
+	return header
+}